@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// circuitBreaker circuit of the database, opened after consecutive lost connections
+// so the next calls fail fast during an outage instead of waiting for the connection timeout
+//
+// Once the cooldown has elapsed, a single caller probes the database with a ping,
+// closing the circuit on success and opening it again for another cooldown otherwise.
+// It is safe for concurrent use and shared by the scoped copies of the Dao
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+	probing  bool
+}
+
+// newCircuitBreaker create a circuit opening after threshold consecutive failures for the cooldown
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow report whether a call may run, probing the database with ping
+// when the circuit is open and its cooldown has elapsed
+func (b *circuitBreaker) allow(ctx context.Context, now time.Time, ping func(context.Context) error) error {
+	b.mu.Lock()
+	if !b.open {
+		b.mu.Unlock()
+		return nil
+	}
+	if b.probing || now.Sub(b.openedAt) < b.cooldown {
+		b.mu.Unlock()
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	b.mu.Unlock()
+
+	err := ping(ctx)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if err != nil {
+		b.openedAt = now
+		return ErrCircuitOpen
+	}
+	b.open = false
+	b.failures = 0
+
+	return nil
+}
+
+// record count the outcome of a call, a lost connection being a failure,
+// and report whether it opened the circuit
+func (b *circuitBreaker) record(now time.Time, err error) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !isConnectionLost(err) {
+		b.failures = 0
+		return false
+	}
+
+	b.failures++
+	if b.open || b.failures < b.threshold {
+		return false
+	}
+
+	b.open = true
+	b.openedAt = now
+
+	return true
+}
+
+// checkCircuit fail fast with ErrCircuitOpen while the circuit of the database is open
+func (db *Dao) checkCircuit(ctx context.Context) error {
+	if db.breaker == nil {
+		return nil
+	}
+
+	return db.breaker.allow(ctx, db.clock.Now(), db.Connection.PingContext)
+}
+
+// recordCircuit count the outcome of a call in the circuit of the database
+func (db *Dao) recordCircuit(err error) {
+	if db.breaker != nil && db.breaker.record(db.clock.Now(), err) {
+		db.logger.Errorf("session circuit opened after %d lost connections, failing fast for %s", db.breaker.threshold, db.breaker.cooldown)
+	}
+}
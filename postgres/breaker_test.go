@@ -0,0 +1,135 @@
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker(2, time.Minute)
+	now := time.Now()
+
+	pings := 0
+	down := errors.New("down")
+	ping := func(context.Context) error {
+		pings++
+		return down
+	}
+
+	if b.record(now, driver.ErrBadConn) {
+		t.Error("record() opened the circuit before the threshold")
+	}
+	b.record(now, errors.New("not a lost connection")) // resets the failures
+	b.record(now, driver.ErrBadConn)
+	if !b.record(now, driver.ErrBadConn) {
+		t.Fatal("record() did not open the circuit at the threshold")
+	}
+
+	if err := b.allow(context.Background(), now.Add(time.Second), ping); err != ErrCircuitOpen || pings != 0 {
+		t.Errorf("allow() during the cooldown == %v, %d pings, want %v, %d", err, pings, ErrCircuitOpen, 0)
+	}
+	if err := b.allow(context.Background(), now.Add(time.Minute), ping); err != ErrCircuitOpen || pings != 1 {
+		t.Errorf("allow() with a failed probe == %v, %d pings, want %v, %d", err, pings, ErrCircuitOpen, 1)
+	}
+
+	down = nil
+	if err := b.allow(context.Background(), now.Add(90*time.Second), ping); err != ErrCircuitOpen || pings != 1 {
+		t.Errorf("allow() during the next cooldown == %v, %d pings, want %v, %d", err, pings, ErrCircuitOpen, 1)
+	}
+	if err := b.allow(context.Background(), now.Add(2*time.Minute), ping); err != nil || pings != 2 {
+		t.Errorf("allow() with a successful probe == %v, %d pings, want %v, %d", err, pings, nil, 2)
+	}
+	if err := b.allow(context.Background(), now.Add(2*time.Minute), ping); err != nil || pings != 2 {
+		t.Errorf("allow() once closed == %v, %d pings, want %v, %d", err, pings, nil, 2)
+	}
+}
+
+func TestDaoCircuitOpen(t *testing.T) {
+	db, err := NewDaoWithConfig("postgres", "postgres://127.0.0.1:1/session?sslmode=disable", "session", DaoConfig{
+		CircuitBreakerThreshold: 2,
+		CircuitBreakerCooldown:  time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err = db.getSessionBySessionID([]byte("down")); !isConnectionLost(err) {
+			t.Fatalf("getSessionBySessionID() == %v, want a lost connection", err)
+		}
+	}
+
+	if _, err = db.getSessionBySessionID([]byte("down")); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("getSessionBySessionID() == %v, want %v", err, ErrCircuitOpen)
+	}
+
+	other, err := db.Table("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.countSessionsErr(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("countSessionsErr() of another table == %v, want %v", err, ErrCircuitOpen)
+	}
+}
+
+func TestDaoCircuitClock(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	db, err := NewDaoWithConfig("postgres", "postgres://127.0.0.1:1/session?sslmode=disable", "session", DaoConfig{
+		CircuitBreakerThreshold: 2,
+		CircuitBreakerCooldown:  time.Hour,
+		Clock:                   clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 2; i++ {
+		db.getSessionBySessionID([]byte("down"))
+	}
+	if !db.breaker.openedAt.Equal(clock.now) {
+		t.Errorf("openedAt == %v, want the clock time %v", db.breaker.openedAt, clock.now)
+	}
+
+	clock.now = clock.now.Add(time.Hour)
+
+	if _, err = db.getSessionBySessionID([]byte("down")); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("getSessionBySessionID() with a failed probe == %v, want %v", err, ErrCircuitOpen)
+	}
+	if !db.breaker.openedAt.Equal(clock.now) {
+		t.Errorf("openedAt == %v after the probe, want the clock time %v", db.breaker.openedAt, clock.now)
+	}
+}
+
+func TestSQLiteDaoCircuitTimeout(t *testing.T) {
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{
+		CircuitBreakerThreshold: 1,
+		CircuitBreakerCooldown:  time.Hour,
+	})
+	defer db.Close()
+
+	db.save([]byte("session"), nil, time.Now(), time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	if _, err := db.getSessionBySessionIDContext(ctx, []byte("session")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("getSessionBySessionIDContext() error == %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := db.getSessionBySessionIDContext(canceled, []byte("session")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("getSessionBySessionIDContext() error == %v, want %v", err, context.Canceled)
+	}
+
+	if _, err := db.getSessionBySessionID([]byte("session")); err != nil {
+		t.Errorf("getSessionBySessionID() after the timeouts error == %v, want the circuit closed", err)
+	}
+}
@@ -0,0 +1,170 @@
+package postgres
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// rowCache size bounded LRU cache of the session rows read by id,
+// each entry valid for the ttl after being read
+//
+// It is safe for concurrent use and shared by the scoped copies of the Dao,
+// the keys carry the table and the tenant of the row
+type rowCache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	items map[string]*list.Element
+	order *list.List
+}
+
+type rowCacheEntry struct {
+	key     string
+	row     DBRow
+	expires time.Time
+}
+
+// newRowCache create a cache of at most size rows valid for the ttl
+func newRowCache(size int, ttl time.Duration) *rowCache {
+	return &rowCache{
+		size:  size,
+		ttl:   ttl,
+		items: make(map[string]*list.Element, size),
+		order: list.New(),
+	}
+}
+
+// get copy the cached row of the key into row, reporting whether it was found and still valid
+func (c *rowCache) get(key string, now time.Time, row *DBRow) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem := c.items[key]
+	if elem == nil {
+		return false
+	}
+
+	entry := elem.Value.(*rowCacheEntry)
+	if !now.Before(entry.expires) {
+		c.removeElement(elem)
+		return false
+	}
+	c.order.MoveToFront(elem)
+
+	*row = entry.row
+
+	return true
+}
+
+// add cache a copy of the row under the key, evicting the least recently used row when full
+func (c *rowCache) add(key string, now time.Time, row *DBRow) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem := c.items[key]; elem != nil {
+		entry := elem.Value.(*rowCacheEntry)
+		entry.row = *row
+		entry.expires = now.Add(c.ttl)
+		c.order.MoveToFront(elem)
+
+		return
+	}
+
+	if c.order.Len() >= c.size {
+		c.removeElement(c.order.Back())
+	}
+
+	c.items[key] = c.order.PushFront(&rowCacheEntry{key: key, row: *row, expires: now.Add(c.ttl)})
+}
+
+// remove drop the cached row of the key
+func (c *rowCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem := c.items[key]; elem != nil {
+		c.removeElement(elem)
+	}
+}
+
+// purge drop all the cached rows
+func (c *rowCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = make(map[string]*list.Element, c.size)
+	c.order.Init()
+}
+
+// len return the number of cached rows, the expired ones included
+func (c *rowCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+func (c *rowCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*rowCacheEntry).key)
+}
+
+// cacheKey return the key of the session in the row cache, scoped to the table and the tenant
+func (db *Dao) cacheKey(sessionID []byte) string {
+	return db.tableName + "\x00" + db.tenantID + "\x00" + string(sessionID)
+}
+
+// cachedRow return a pooled copy of the cached row of the session, nil when it is not cached,
+// the cache being bypassed inside a transaction
+func (db *Dao) cachedRow(sessionID []byte) *DBRow {
+	if db.cache == nil || db.tx != nil {
+		return nil
+	}
+
+	now := db.clock.Now()
+	row := acquireDBRow()
+
+	if !db.cache.get(db.cacheKey(sessionID), now, row) {
+		releaseDBRow(row)
+		return nil
+	}
+
+	if row.expiration > 0 && !now.Before(row.lastActive.Add(row.expiration)) {
+		releaseDBRow(row)
+		return nil
+	}
+	if !row.expiresAt.IsZero() && !now.Before(row.expiresAt) {
+		releaseDBRow(row)
+		return nil
+	}
+
+	return row
+}
+
+// cacheRow cache a copy of the row read from the database
+func (db *Dao) cacheRow(sessionID []byte, row *DBRow) {
+	if db.cache == nil || db.tx != nil {
+		return
+	}
+
+	db.cache.add(db.cacheKey(sessionID), db.clock.Now(), row)
+}
+
+// invalidate drop the cached rows of the sessions written by the Dao
+func (db *Dao) invalidate(sessionIDs ...[]byte) {
+	if db.cache == nil {
+		return
+	}
+
+	for _, sessionID := range sessionIDs {
+		db.cache.remove(db.cacheKey(sessionID))
+	}
+}
+
+// invalidateAll drop all the cached rows, after a write of many sessions
+func (db *Dao) invalidateAll() {
+	if db.cache != nil {
+		db.cache.purge()
+	}
+}
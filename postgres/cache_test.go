@@ -0,0 +1,43 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRowCache(t *testing.T) {
+	c := newRowCache(2, time.Minute)
+	now := time.Now()
+
+	c.add("first", now, &DBRow{sessionID: "first"})
+	c.add("second", now, &DBRow{sessionID: "second"})
+
+	var row DBRow
+	if !c.get("first", now, &row) || row.sessionID != "first" {
+		t.Errorf("get() == %v, want %s", row, "first")
+	}
+
+	c.add("third", now, &DBRow{sessionID: "third"}) // evicts the least recently used second
+
+	if c.get("second", now, &row) {
+		t.Errorf("get() == %v, want the evicted row to be missing", row)
+	}
+	if c.len() != 2 {
+		t.Errorf("len() == %d, want %d", c.len(), 2)
+	}
+
+	if c.get("first", now.Add(time.Minute), &row) {
+		t.Errorf("get() == %v, want the expired row to be missing", row)
+	}
+
+	c.remove("third")
+	if c.get("third", now, &row) {
+		t.Errorf("get() == %v, want the removed row to be missing", row)
+	}
+
+	c.add("fourth", now, &DBRow{sessionID: "fourth"})
+	c.purge()
+	if c.len() != 0 {
+		t.Errorf("len() == %d, want %d", c.len(), 0)
+	}
+}
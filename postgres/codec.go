@@ -0,0 +1,74 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"time"
+)
+
+// JSONCodec codec of the values encoded as JSON, used by default
+var JSONCodec Codec = jsonCodec{}
+
+// GobCodec codec of the values encoded with encoding/gob, binary data
+// requiring the binary contents or the encryption
+var GobCodec Codec = gobCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// save the value encoded by the codec of the Dao as the session contents
+func (db *Dao) saveValue(sessionID []byte, v interface{}, lastActive time.Time, expiration time.Duration) (int64, error) {
+	return db.saveValueContext(context.Background(), sessionID, v, lastActive, expiration)
+}
+
+// save the value encoded by the codec of the Dao as the session contents bound to ctx
+func (db *Dao) saveValueContext(ctx context.Context, sessionID []byte, v interface{}, lastActive time.Time, expiration time.Duration) (int64, error) {
+	contents, err := db.codec.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+
+	return db.saveContext(ctx, sessionID, contents, lastActive, expiration)
+}
+
+// load the session contents decoded by the codec of the Dao into the value v points to
+//
+// It fails with ErrSessionNotFound when the session does not exist or is expired
+func (db *Dao) loadValue(sessionID []byte, v interface{}) error {
+	return db.loadValueContext(context.Background(), sessionID, v)
+}
+
+// load the session contents decoded by the codec of the Dao into v bound to ctx
+func (db *Dao) loadValueContext(ctx context.Context, sessionID []byte, v interface{}) error {
+	row, err := db.getSessionBySessionIDContext(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	defer row.Release()
+
+	return db.codec.Unmarshal([]byte(row.contents), v)
+}
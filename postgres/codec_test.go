@@ -0,0 +1,48 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+)
+
+type testValue struct {
+	UserID int
+	Roles  []string
+}
+
+func TestSQLiteDaoSaveLoadValue(t *testing.T) {
+	for _, cfg := range []DaoConfig{{Codec: JSONCodec}, {Codec: GobCodec, BinaryContents: true}} {
+		db := getSQLiteTestDaoWithConfig(t, cfg)
+
+		sessionID := []byte("value")
+		saved := testValue{UserID: 42, Roles: []string{"admin"}}
+		if _, err := db.saveValue(sessionID, saved, time.Now(), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+
+		var loaded testValue
+		if err := db.loadValue(sessionID, &loaded); err != nil {
+			t.Fatal(err)
+		}
+		if loaded.UserID != saved.UserID || len(loaded.Roles) != 1 || loaded.Roles[0] != "admin" {
+			t.Errorf("loadValue() == %+v, want %+v", loaded, saved)
+		}
+
+		if err := db.loadValue([]byte("missing"), &loaded); err != ErrSessionNotFound {
+			t.Errorf("loadValue() error == %v, want %v", err, ErrSessionNotFound)
+		}
+
+		db.Close()
+	}
+}
+
+func TestGobCodecTextContents(t *testing.T) {
+	if _, err := newDao("session", DaoConfig{Codec: GobCodec}); err != errGobTextContents {
+		t.Errorf("newDao() error == %v, want %v", err, errGobTextContents)
+	}
+
+	key := make([]byte, encryptionKeyLen)
+	if _, err := newDao("session", DaoConfig{Codec: GobCodec, EncryptionKey: key}); err != nil {
+		t.Errorf("newDao() with the encrypted contents unexpected error: %v", err)
+	}
+}
@@ -1,8 +1,11 @@
 package postgres
 
 import (
-	"fmt"
+	"net"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // NewConfigWith instance new configuration with especific paremters
@@ -28,19 +31,192 @@ func NewDefaultConfig() *Config {
 		ConnTimeout:    3000,
 		Database:       "session",
 		TableName:      "session",
-		SetMaxOpenConn: 500,
-		SetMaxIdleConn: 50,
+		SetMaxOpenConn: defaultMaxOpenConns,
+		SetMaxIdleConn: defaultMaxIdleConns,
 	}
 }
 
+// NewDefaultDaoConfig return default database access object configuration
+//
+// It allows 500 open and 50 idle connections, recycles connections
+// after 30 minutes, closes the ones idle for more than 5 minutes
+// and verifies the connection on creation
+func NewDefaultDaoConfig() DaoConfig {
+	return DaoConfig{
+		MaxOpenConns:     defaultMaxOpenConns,
+		MaxIdleConns:     defaultMaxIdleConns,
+		ConnMaxLifetime:  defaultConnMaxLifetime,
+		ConnMaxIdleTime:  defaultConnMaxIdleTime,
+		VerifyConnection: true,
+	}
+}
+
+// withDefaults return a copy of the column names with the empty ones set to the defaults
+func (cn ColumnNames) withDefaults() ColumnNames {
+	if cn.SessionID == "" {
+		cn.SessionID = defaultColumnSessionID
+	}
+	if cn.Contents == "" {
+		cn.Contents = defaultColumnContents
+	}
+	if cn.LastActive == "" {
+		cn.LastActive = defaultColumnLastActive
+	}
+	if cn.Expiration == "" {
+		cn.Expiration = defaultColumnExpiration
+	}
+	if cn.ExpiresAt == "" {
+		cn.ExpiresAt = defaultColumnExpiresAt
+	}
+	if cn.CreatedAt == "" {
+		cn.CreatedAt = defaultColumnCreatedAt
+	}
+	if cn.TenantID == "" {
+		cn.TenantID = defaultColumnTenantID
+	}
+
+	return cn
+}
+
+// validate check every column name is a plain identifier, since they are interpolated unquoted
+func (cn ColumnNames) validate() error {
+	for _, name := range []string{cn.SessionID, cn.Contents, cn.LastActive, cn.Expiration, cn.ExpiresAt, cn.CreatedAt, cn.TenantID} {
+		if err := validateIdentifier(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (pc *Config) getPostgresDSN() string {
-	return fmt.Sprintf("postgresql://%s:%s@%s:%d/%s?connect_timeout=%d&sslmode=disable",
-		url.QueryEscape(pc.Username),
-		pc.Password,
-		url.QueryEscape(pc.Host),
-		pc.Port,
-		url.QueryEscape(pc.Database),
-		pc.ConnTimeout)
+	sslMode := pc.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	applicationName := pc.ApplicationName
+	if applicationName == "" {
+		applicationName = pc.TableName
+	}
+
+	return ConnectionOptions{
+		Host:            pc.Host,
+		Port:            pc.Port,
+		Username:        pc.Username,
+		Password:        pc.Password,
+		Database:        pc.Database,
+		ConnTimeout:     pc.ConnTimeout,
+		SSLMode:         sslMode,
+		SSLRootCert:     pc.SSLRootCert,
+		SSLCert:         pc.SSLCert,
+		SSLKey:          pc.SSLKey,
+		ApplicationName: applicationName,
+	}.DSN()
+}
+
+// DSN return the postgres connection url of the options, escaping every part of it
+//
+// The empty options are left to the driver defaults
+func (o ConnectionOptions) DSN() string {
+	query := url.Values{}
+	if o.ConnTimeout > 0 {
+		query.Set("connect_timeout", strconv.FormatInt(o.ConnTimeout, 10))
+	}
+	if o.SSLMode != "" {
+		query.Set("sslmode", o.SSLMode)
+	}
+	if o.SSLRootCert != "" {
+		query.Set("sslrootcert", o.SSLRootCert)
+	}
+	if o.SSLCert != "" {
+		query.Set("sslcert", o.SSLCert)
+	}
+	if o.SSLKey != "" {
+		query.Set("sslkey", o.SSLKey)
+	}
+	if o.ApplicationName != "" {
+		query.Set("application_name", o.ApplicationName)
+	}
+
+	dsn := url.URL{Scheme: "postgresql", Path: "/" + o.Database}
+
+	host := o.Host
+	if strings.HasPrefix(host, "/") { // unix domain socket directory
+		query.Set("host", host)
+		host = ""
+	}
+	if o.Port > 0 {
+		host = net.JoinHostPort(host, strconv.FormatInt(o.Port, 10))
+	}
+	dsn.Host = host
+
+	if o.Password != "" {
+		dsn.User = url.UserPassword(o.Username, o.Password)
+	} else if o.Username != "" {
+		dsn.User = url.User(o.Username)
+	}
+
+	dsn.RawQuery = query.Encode()
+
+	return dsn.String()
+}
+
+// withServerOptions return the dsn with the statement timeout and the search path of the Dao
+// added to its options parameter, in the url or the key value form
+//
+// The dsn of the dialects other than postgres is returned as is
+func (db *Dao) withServerOptions(dsn string) string {
+	if !isPostgresCompatible(db.dialect) {
+		return dsn
+	}
+
+	var settings []string
+	if db.statementTimeout > 0 {
+		settings = append(settings, "-c statement_timeout="+statementTimeoutMillis(db.statementTimeout))
+	}
+	if db.searchPath != "" {
+		// the server splits the options on the unescaped spaces
+		settings = append(settings, "-c search_path="+strings.Replace(db.searchPath, " ", "\\ ", -1))
+	}
+	if len(settings) == 0 {
+		return dsn
+	}
+	option := strings.Join(settings, " ")
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return dsn // left to the driver to report
+		}
+
+		query := u.Query()
+		if options := query.Get("options"); options != "" {
+			option = options + " " + option
+		}
+		query.Set("options", option)
+		u.RawQuery = query.Encode()
+
+		return u.String()
+	}
+
+	return dsn + " options='" + strings.NewReplacer("\\", "\\\\", "'", "\\'").Replace(option) + "'"
+}
+
+// quoteSearchPath return the search_path value of the schemas, quoted as identifiers
+func quoteSearchPath(schemas []string) string {
+	quoted := make([]string, len(schemas))
+	for i, schema := range schemas {
+		quoted[i] = quoteIdentifier(schema)
+	}
+
+	return strings.Join(quoted, ",")
+}
+
+// statementTimeoutMillis return the statement timeout in milliseconds, rounded up
+func statementTimeoutMillis(d time.Duration) string {
+	ms := (d + time.Millisecond - 1) / time.Millisecond
+
+	return strconv.FormatInt(int64(ms), 10)
 }
 
 // Name return provider name
@@ -1,4 +1,97 @@
 package postgres
 
+import (
+	"math"
+	"time"
+)
+
 // ProviderName postgres provider name
 const ProviderName = "postgres"
+
+// SessionNeverExpires expiration of the sessions which never expire, stored as 0
+const SessionNeverExpires time.Duration = 0
+
+// expirationBucketBeyond bucket of countByExpirationBucket
+// counting the sessions above the last bucket
+const expirationBucketBeyond time.Duration = math.MaxInt64
+
+const defaultMaxOpenConns = 500
+const defaultMaxIdleConns = 50
+const defaultConnMaxLifetime = 30 * time.Minute
+const defaultConnMaxIdleTime = 5 * time.Minute
+
+// rows of the multi-row inserts, below the 999 bind parameters of the older sqlite versions
+// with the 6 parameters of the rows carrying their deadline and creation time
+const insertBatchSize = 160
+
+// interval between the probes of a failover dsn found down
+const defaultFailoverProbeInterval = 30 * time.Second
+
+const defaultCacheTTL = time.Second
+
+const defaultCircuitBreakerCooldown = 5 * time.Second
+
+// default timeout of the operations on a single session or a bounded set of them,
+// the bulk ones like the gc, the imports, the copies and the iterations having none
+const defaultOpTimeout = time.Minute
+
+var defaultOpTimeouts = map[string]time.Duration{
+	opGet:         defaultOpTimeout,
+	opCount:       defaultOpTimeout,
+	opList:        defaultOpTimeout,
+	opFind:        defaultOpTimeout,
+	opConsume:     defaultOpTimeout,
+	opUpdate:      defaultOpTimeout,
+	opTouch:       defaultOpTimeout,
+	opExpireAt:    defaultOpTimeout,
+	opDelete:      defaultOpTimeout,
+	opInsert:      defaultOpTimeout,
+	opGetOrCreate: defaultOpTimeout,
+	opSave:        defaultOpTimeout,
+	opRegenerate:  defaultOpTimeout,
+}
+
+// delays between the attempts of the initial ping, doubled from the default up to the max
+const defaultConnectRetryDelay = time.Second
+const maxConnectRetryDelay = 30 * time.Second
+
+// sqlDefaultMaxIdleConns idle connections kept by database/sql without MaxIdleConns
+const sqlDefaultMaxIdleConns = 2
+
+const defaultColumnSessionID = "session_id"
+const defaultColumnContents = "contents"
+const defaultColumnLastActive = "last_active"
+const defaultColumnExpiration = "expiration"
+const defaultColumnExpiresAt = "expires_at"
+const defaultColumnCreatedAt = "created_at"
+const defaultColumnTenantID = "tenant_id"
+
+const encryptionKeyLen = 32
+
+const compressedContentsMarker byte = 0x01
+const rawContentsMarker byte = 0x02
+const versionedContentsMarker byte = 0x03
+
+// operation names used by the logs and the metrics
+const (
+	opGet         = "get"
+	opCount       = "count"
+	opList        = "list"
+	opIterate     = "iterate"
+	opFind        = "find"
+	opConsume     = "consume"
+	opUpdate      = "update"
+	opTouch       = "touch"
+	opExpireAt    = "expire_at"
+	opDelete      = "delete"
+	opGC          = "gc"
+	opGCLock      = "gc_lock"
+	opInsert      = "insert"
+	opImport      = "import"
+	opCopy        = "copy"
+	opGetOrCreate = "get_or_create"
+	opSave        = "save"
+	opRegenerate  = "regenerate"
+	opNotify      = "notify"
+	opPartition   = "partition"
+)
@@ -0,0 +1,260 @@
+package postgres
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+
+	"github.com/savsgio/gotils"
+	"github.com/valyala/bytebufferpool"
+)
+
+var emptyJSONContents = []byte("{}")
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// newAEAD create the AES-GCM cipher of the 32 bytes key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != encryptionKeyLen {
+		return nil, errInvalidEncryptionKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encodeContents prepare the session contents to be written to the database,
+// compressing and then encrypting them when enabled
+//
+// In the JSON contents mode they must be valid JSON, and the empty ones are stored as {}
+func (db *Dao) encodeContents(contents []byte) ([]byte, error) {
+	if db.jsonContents {
+		if len(contents) == 0 {
+			return emptyJSONContents, nil
+		}
+		if !json.Valid(contents) {
+			return nil, errInvalidJSONContents
+		}
+
+		return contents, nil
+	}
+
+	if db.contentsVersion > 0 {
+		contents = append([]byte{versionedContentsMarker, db.contentsVersion}, contents...)
+	}
+
+	contents, err := db.compressContents(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.encryptContents(contents)
+}
+
+// decodeContents revert encodeContents on the contents read from the database,
+// upgrading them to the current version when the versioning is enabled
+func (db *Dao) decodeContents(contents string) (string, error) {
+	contents, err := db.decryptContents(contents)
+	if err != nil {
+		return "", err
+	}
+
+	contents, err = db.decompressContents(contents)
+	if err != nil || db.contentsVersion == 0 {
+		return contents, err
+	}
+
+	return db.upgradeContents(contents)
+}
+
+// upgradeContents strip the version header of the contents and chain the upgrades
+// from their version to the current one, the contents without header being the version 0
+func (db *Dao) upgradeContents(contents string) (string, error) {
+	var version byte
+	if len(contents) >= 2 && contents[0] == versionedContentsMarker {
+		version = contents[1]
+		contents = contents[2:]
+	}
+
+	if version > db.contentsVersion {
+		return "", errUnknownContentsVersion(version)
+	}
+
+	if version == db.contentsVersion {
+		return contents, nil
+	}
+
+	plain := []byte(contents) // the upgrades may modify it in place
+	for ; version < db.contentsVersion; version++ {
+		upgrade := db.contentsUpgrades[version]
+		if upgrade == nil {
+			return "", errMissingContentsUpgrade(version)
+		}
+
+		upgraded, err := upgrade(plain)
+		if err != nil {
+			return "", err
+		}
+		plain = upgraded
+	}
+
+	// the upgrades may return a buffer they keep using, unlike the decoders above
+	return string(plain), nil
+}
+
+// contentsArg return the encoded contents as the query parameter of the contents column,
+// the bytes themselves in the binary contents mode and a string otherwise,
+// since the drivers write the bytes parameters as bytea
+//
+// Neither is a copy, the contents must stay untouched until the query returns.
+// A ContentsValue of the configuration takes precedence, writing the column itself
+func (db *Dao) contentsArg(contents []byte) interface{} {
+	if db.contentsValue != nil {
+		value := db.contentsValue()
+		value.SetContents(contents)
+
+		return value
+	}
+
+	if db.binaryContents {
+		if contents == nil {
+			return []byte{}
+		}
+
+		return contents
+	}
+
+	return gotils.B2S(contents)
+}
+
+// compressContents gzip the contents longer than the compression threshold
+//
+// The compressed contents are prefixed with a marker byte and base64 encoded,
+// the uncompressed ones are stored as is unless they start with a marker byte,
+// so the rows written without compression are still readable
+func (db *Dao) compressContents(contents []byte) ([]byte, error) {
+	if db.compressionThreshold <= 0 {
+		return contents, nil
+	}
+
+	if len(contents) <= db.compressionThreshold {
+		if len(contents) > 0 && (contents[0] == compressedContentsMarker || contents[0] == rawContentsMarker) {
+			return append([]byte{rawContentsMarker}, contents...), nil
+		}
+
+		return contents, nil
+	}
+
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+
+	zw := gzipWriterPool.Get().(*gzip.Writer)
+	defer gzipWriterPool.Put(zw)
+
+	zw.Reset(buf)
+	if _, err := zw.Write(contents); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	dst := make([]byte, 1+base64.StdEncoding.EncodedLen(buf.Len()))
+	dst[0] = compressedContentsMarker
+	base64.StdEncoding.Encode(dst[1:], buf.B)
+
+	return dst, nil
+}
+
+// decompressContents revert compressContents
+//
+// Without compression the contents are returned as is, the marker bytes
+// being the first bytes of the contents themselves
+func (db *Dao) decompressContents(contents string) (string, error) {
+	if db.compressionThreshold <= 0 || len(contents) == 0 {
+		return contents, nil
+	}
+
+	switch contents[0] {
+	case rawContentsMarker:
+		return contents[1:], nil
+	case compressedContentsMarker:
+		compressed, err := base64.StdEncoding.DecodeString(contents[1:])
+		if err != nil {
+			return "", err
+		}
+
+		zr, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return "", err
+		}
+		defer zr.Close()
+
+		plain, err := ioutil.ReadAll(zr)
+		if err != nil {
+			return "", err
+		}
+
+		return gotils.B2S(plain), nil
+	}
+
+	return contents, nil
+}
+
+// encryptContents seal the contents with a random nonce prefix and base64 encode them,
+// so they still fit into a text column
+func (db *Dao) encryptContents(contents []byte) ([]byte, error) {
+	if db.aead == nil {
+		return contents, nil
+	}
+
+	nonceSize := db.aead.NonceSize()
+	sealed := make([]byte, nonceSize, nonceSize+len(contents)+db.aead.Overhead())
+	if _, err := rand.Read(sealed); err != nil {
+		return nil, err
+	}
+	sealed = db.aead.Seal(sealed, sealed, contents, nil)
+
+	dst := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(dst, sealed)
+
+	return dst, nil
+}
+
+// decryptContents revert encryptContents
+func (db *Dao) decryptContents(contents string) (string, error) {
+	if db.aead == nil {
+		return contents, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(contents)
+	if err != nil {
+		return "", err
+	}
+
+	nonceSize := db.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", errInvalidEncryptedContents
+	}
+
+	plain, err := db.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", err
+	}
+
+	return gotils.B2S(plain), nil
+}
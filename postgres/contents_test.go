@@ -0,0 +1,151 @@
+package postgres
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptContents(t *testing.T) {
+	aead, err := newAEAD(bytes.Repeat([]byte("k"), encryptionKeyLen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &Dao{aead: aead}
+
+	plain := []byte("user_id=1")
+
+	encoded, err := db.encodeContents(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encoded, plain) {
+		t.Errorf("encoded contents must not contain the plaintext: %s", encoded)
+	}
+
+	decoded, err := db.decodeContents(string(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded != string(plain) {
+		t.Errorf("decodeContents() == %s, want %s", decoded, plain)
+	}
+}
+
+func TestEncryptionKeyLength(t *testing.T) {
+	if _, err := newAEAD([]byte("short")); err != errInvalidEncryptionKey {
+		t.Errorf("newAEAD() error == %v, want %v", err, errInvalidEncryptionKey)
+	}
+}
+
+func TestCompressContents(t *testing.T) {
+	db := &Dao{compressionThreshold: 16}
+
+	cases := [][]byte{
+		nil,
+		[]byte("short"),
+		[]byte{compressedContentsMarker, 'a'},
+		bytes.Repeat([]byte("long contents "), 100),
+	}
+
+	for _, plain := range cases {
+		encoded, err := db.encodeContents(plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := db.decodeContents(string(encoded))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded != string(plain) {
+			t.Errorf("decodeContents() == %q, want %q", decoded, plain)
+		}
+	}
+
+	long := cases[len(cases)-1]
+	encoded, _ := db.encodeContents(long)
+	if len(encoded) >= len(long) {
+		t.Errorf("compressed contents length == %d, want less than %d", len(encoded), len(long))
+	}
+
+	if decoded, _ := db.decodeContents("uncompressed"); decoded != "uncompressed" {
+		t.Errorf("decodeContents() == %s, want %s", decoded, "uncompressed")
+	}
+}
+
+func TestJSONContents(t *testing.T) {
+	db := &Dao{jsonContents: true}
+
+	if encoded, _ := db.encodeContents(nil); string(encoded) != "{}" {
+		t.Errorf("encodeContents(nil) == %s, want %s", encoded, "{}")
+	}
+
+	if _, err := db.encodeContents([]byte("not json")); err != errInvalidJSONContents {
+		t.Errorf("encodeContents() error == %v, want %v", err, errInvalidJSONContents)
+	}
+
+	if _, err := newDao("session", DaoConfig{JSONContents: true, CompressionThreshold: 1}); err != errJSONContentsEncoding {
+		t.Errorf("newDao() error == %v, want %v", err, errJSONContentsEncoding)
+	}
+}
+
+func TestVersionContents(t *testing.T) {
+	db := &Dao{
+		contentsVersion: 2,
+		contentsUpgrades: map[byte]func([]byte) ([]byte, error){
+			0: func(contents []byte) ([]byte, error) { return append(contents, "+v1"...), nil },
+			1: func(contents []byte) ([]byte, error) { return append(contents, "+v2"...), nil },
+		},
+	}
+
+	encoded, err := db.encodeContents([]byte("current"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded[0] != versionedContentsMarker || encoded[1] != 2 {
+		t.Errorf("encodeContents() == %q, want the version 2 header", encoded)
+	}
+
+	cases := []struct {
+		stored, expected string
+	}{
+		{string(encoded), "current"},
+		{"legacy", "legacy+v1+v2"},
+		{"\x03\x01older", "older+v2"},
+	}
+
+	for _, c := range cases {
+		if decoded, err := db.decodeContents(c.stored); err != nil || decoded != c.expected {
+			t.Errorf("decodeContents(%q) == %q, %v, want %q", c.stored, decoded, err, c.expected)
+		}
+	}
+
+	if _, err := db.decodeContents("\x03\x03newer"); err == nil {
+		t.Error("decodeContents() of a newer version unexpected success")
+	}
+
+	delete(db.contentsUpgrades, 1)
+	if _, err := db.decodeContents("legacy"); err == nil {
+		t.Error("decodeContents() with a missing upgrade unexpected success")
+	}
+}
+
+func TestUpgradeContentsCopy(t *testing.T) {
+	shared := make([]byte, 0, 16)
+	db := &Dao{
+		contentsVersion: 1,
+		contentsUpgrades: map[byte]func([]byte) ([]byte, error){
+			0: func(contents []byte) ([]byte, error) { return append(shared[:0], contents...), nil },
+		},
+	}
+
+	decoded, err := db.decodeContents("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copy(shared[:cap(shared)], "overwritten")
+	if decoded != "legacy" {
+		t.Errorf("decodeContents() == %q after the upgrade buffer reuse, want %q", decoded, "legacy")
+	}
+}
@@ -1,13 +1,18 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	// Import postgres driver
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/savsgio/gotils"
 )
 
@@ -26,88 +31,2914 @@ func releaseDBRow(row *DBRow) {
 	dbRowPool.Put(row)
 }
 
+// Release reset the row and return it to the pool, it must not be used afterwards
+func (row *DBRow) Release() {
+	if row != nil {
+		releaseDBRow(row)
+	}
+}
+
+// SessionID return a copy of the session id of the row
+func (row *DBRow) SessionID() []byte {
+	return []byte(row.sessionID)
+}
+
+// Contents return a copy of the decoded session contents of the row
+func (row *DBRow) Contents() []byte {
+	return []byte(row.contents)
+}
+
+// LastActive return the time of the last activity of the session
+func (row *DBRow) LastActive() time.Time {
+	return row.lastActive
+}
+
+// Expiration return the expiration of the session relative to its last activity,
+// SessionNeverExpires for a session which never expires
+func (row *DBRow) Expiration() time.Duration {
+	return row.expiration
+}
+
+// ExpiresAt return the absolute deadline of the session, the zero time without one
+// or when the expires at mode is disabled
+func (row *DBRow) ExpiresAt() time.Time {
+	return row.expiresAt
+}
+
+// CreatedAt return the time of the creation of the session, the zero time when the created at mode is disabled
+func (row *DBRow) CreatedAt() time.Time {
+	return row.createdAt
+}
+
 // Reset reset database row memory
 func (row *DBRow) Reset() {
 	row.sessionID = ""
 	row.contents = ""
-	row.lastActive = 0
+	row.lastActive = time.Time{}
+	row.expiration = 0
+	row.expiresAt = time.Time{}
+	row.createdAt = time.Time{}
 }
 
-// NewDao create new database access object
+// NewDao create new database access object with the default pool configuration
 func NewDao(driver, dsn, tableName string) (*Dao, error) {
-	db := &Dao{tableName: tableName}
+	return NewDaoWithConfig(driver, dsn, tableName, NewDefaultDaoConfig())
+}
+
+// NewDaoContext create new database access object with the default pool configuration,
+// failing when the connection is not verified before ctx is done
+func NewDaoContext(ctx context.Context, driver, dsn, tableName string) (*Dao, error) {
+	return NewDaoWithConfigContext(ctx, driver, dsn, tableName, NewDefaultDaoConfig())
+}
+
+// NewSQLiteDao create new sqlite database access object
+//
+// The sqlite3 driver must be registered by the caller, e.g. importing github.com/mattn/go-sqlite3.
+// The pool is limited to one connection since sqlite serializes the writers
+func NewSQLiteDao(dsn, tableName string) (*Dao, error) {
+	return NewDaoWithConfig("sqlite3", dsn, tableName, DaoConfig{
+		MaxOpenConns:     1,
+		VerifyConnection: true,
+		Dialect:          SQLiteDialect,
+	})
+}
+
+// NewDaoWithOptions create new postgres database access object connected with the given connection options
+//
+// The application name of the connections defaults to the table name
+func NewDaoWithOptions(opts ConnectionOptions, tableName string, cfg DaoConfig) (*Dao, error) {
+	if opts.ApplicationName == "" {
+		opts.ApplicationName = tableName
+	}
+
+	return NewDaoWithConfig("postgres", opts.DSN(), tableName, cfg)
+}
+
+// NewDaoWithConfig create new database access object with the given pool configuration
+//
+// The table name may be schema qualified, as "schema.table", and any part may be double quoted.
+// The driver must be registered and the dsn and the table name must not be empty
+func NewDaoWithConfig(driver, dsn, tableName string, cfg DaoConfig) (*Dao, error) {
+	return NewDaoWithConfigContext(context.Background(), driver, dsn, tableName, cfg)
+}
+
+// NewDaoWithConfigContext create new database access object with the given pool configuration,
+// bounding the verification of the connection and the creation of the table to ctx
+func NewDaoWithConfigContext(ctx context.Context, driver, dsn, tableName string, cfg DaoConfig) (*Dao, error) {
+	if err := validateDriver(driver); err != nil {
+		return nil, err
+	}
+	if dsn == "" {
+		return nil, errDsnEmpty
+	}
+
+	db, err := newDao(tableName, cfg)
+	if err != nil {
+		return nil, err
+	}
 	db.Driver = driver
-	db.Dsn = dsn
+	db.Dsn = db.withServerOptions(dsn)
 
-	var err error
 	db.Connection, err = sql.Open(db.Driver, db.Dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.connectContext(ctx, cfg); err != nil {
+		return nil, err
+	}
 
-	db.sqlGetSessionBySessionID = fmt.Sprintf("SELECT session_id,contents,last_active,expiration FROM %s WHERE session_id=$1", tableName)
-	db.sqlCountSessions = fmt.Sprintf("SELECT count(*) as total FROM %s", tableName)
-	db.sqlUpdateBySessionID = fmt.Sprintf("UPDATE %s SET contents=$1,last_active=$2,expiration=$3 WHERE session_id=$4", tableName)
-	db.sqlDeleteBySessionID = fmt.Sprintf("DELETE FROM %s WHERE session_id=$1", tableName)
-	db.sqlDeleteExpiredSessions = fmt.Sprintf("DELETE FROM %s WHERE last_active+expiration<=$1 AND expiration<>0", tableName)
-	db.sqlInsert = fmt.Sprintf("INSERT INTO %s (session_id, contents, last_active, expiration) VALUES ($1,$2,$3,$4)", tableName)
-	db.sqlRegenerate = fmt.Sprintf("UPDATE %s SET session_id=$1,last_active=$2,expiration=$3 WHERE session_id=$4", tableName)
+	if cfg.ReadDsn != "" {
+		if err = db.connectReader(ctx, driver, cfg); err != nil {
+			db.Connection.Close()
+			return nil, err
+		}
+	}
 
-	return db, err
+	return db, nil
 }
 
-// get session by sessionID
-func (db *Dao) getSessionBySessionID(sessionID []byte) (*DBRow, error) {
-	data := acquireDBRow()
+// NewDaoFromConnector create new database access object opening its connections with the connector,
+// with the default pool configuration
+//
+// The connector may fetch fresh credentials for every new connection, like the rotating IAM tokens
+func NewDaoFromConnector(c driver.Connector, tableName string) (*Dao, error) {
+	return NewDaoFromConnectorWithConfig(c, tableName, NewDefaultDaoConfig())
+}
 
-	row, err := db.QueryRow(db.sqlGetSessionBySessionID, gotils.B2S(sessionID))
+// NewDaoFromConnectorWithConfig create new database access object opening its connections
+// with the connector, with the given configuration
+//
+// The read replica of the configuration is not supported
+func NewDaoFromConnectorWithConfig(c driver.Connector, tableName string, cfg DaoConfig) (*Dao, error) {
+	db, err := newDao(tableName, cfg)
 	if err != nil {
 		return nil, err
 	}
+	if db.searchPath != "" {
+		c = &searchPathConnector{Connector: c, query: "SET search_path TO " + db.searchPath}
+	}
+	db.Connection = sql.OpenDB(c)
 
-	err = row.Scan(&data.sessionID, &data.contents, &data.lastActive, &data.expiration)
-	if err != nil && err != sql.ErrNoRows {
+	if err = db.connect(cfg); err != nil {
 		return nil, err
 	}
-	data.expiration *= time.Second
 
-	return data, nil
+	return db, nil
 }
 
-// count sessions
-func (db *Dao) countSessions() int {
-	row, err := db.QueryRow(db.sqlCountSessions)
+// NewDaoWithDB create new database access object using the given connection pool,
+// managed by the caller
+func NewDaoWithDB(conn *sql.DB, tableName string) (*Dao, error) {
+	return NewDaoWithDBConfig(conn, tableName, DaoConfig{})
+}
+
+// NewDaoWithDBConfig create new database access object using the given connection pool
+// with the given configuration
+//
+// The pool is managed by the caller, Close does not close it.
+// The read replica of the configuration is not supported
+func NewDaoWithDBConfig(conn *sql.DB, tableName string, cfg DaoConfig) (*Dao, error) {
+	db, err := newDao(tableName, cfg)
 	if err != nil {
-		return 0
+		return nil, err
 	}
+	db.Connection = conn
+	db.sharedConnection = true
 
-	var total int
-	err = row.Scan(&total)
+	if err = db.connect(cfg); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// validateDriver check the driver is registered,
+// rather than failing later on the first connection
+func validateDriver(name string) error {
+	for _, driver := range sql.Drivers() {
+		if driver == name {
+			return nil
+		}
+	}
+
+	return errUnknownDriver(name)
+}
+
+// newDao create new database access object without connection,
+// validating the configuration and building the sql statements
+func newDao(tableName string, cfg DaoConfig) (*Dao, error) {
+	db := &Dao{
+		gc:     new(gcWorker),
+		tables: &tableRegistry{daos: make(map[tableKey]*Dao)},
+		monitor: &connectionMonitor{
+			maxIdleConns: cfg.MaxIdleConns,
+		},
+	}
+	if db.monitor.maxIdleConns == 0 {
+		db.monitor.maxIdleConns = sqlDefaultMaxIdleConns
+	}
+
+	err := db.setTableName(tableName)
 	if err != nil {
-		return 0
+		return nil, err
 	}
 
-	return total
+	if !cfg.DisableStatementCache {
+		db.stmts = &stmtCache{stmts: make(map[string]*sql.Stmt)}
+	}
+
+	db.dialect = cfg.Dialect
+	if db.dialect == nil {
+		db.dialect = PostgresDialect
+	}
+	_, db.copyOnRegenerate = db.dialect.(cockroachDialect)
+
+	db.contentsValue = cfg.ContentsValue
+	db.codec = cfg.Codec
+	if db.codec == nil {
+		db.codec = JSONCodec
+	}
+
+	if cfg.EncryptionKey != nil {
+		if db.aead, err = newAEAD(cfg.EncryptionKey); err != nil {
+			return nil, err
+		}
+	}
+
+	db.compressionThreshold = cfg.CompressionThreshold
+	db.jsonContents = cfg.JSONContents
+	if db.jsonContents && (db.aead != nil || db.compressionThreshold > 0) {
+		return nil, errJSONContentsEncoding
+	}
+
+	db.binaryContents = cfg.BinaryContents
+	if db.binaryContents && db.jsonContents {
+		return nil, errJSONBinaryContents
+	}
+	if db.codec == GobCodec && !db.binaryContents && db.aead == nil {
+		return nil, errGobTextContents
+	}
+
+	db.contentsVersion = cfg.ContentsVersion
+	db.contentsUpgrades = cfg.ContentsUpgrades
+	if db.contentsVersion > 0 && db.jsonContents {
+		return nil, errJSONContentsVersion
+	}
+
+	db.columns = cfg.Columns.withDefaults()
+	if err = db.columns.validate(); err != nil {
+		return nil, err
+	}
+	db.slidingExpiration = cfg.SlidingExpiration
+	db.expiresAt = cfg.ExpiresAt
+	db.createdAt = cfg.CreatedAt
+	db.timeUnit = cfg.TimeUnit
+	if db.timeUnit <= 0 {
+		db.timeUnit = time.Second
+	}
+	db.notifyChannel = cfg.NotifyChannel
+	db.multiTenant = cfg.MultiTenant
+	if _, ok := db.dialect.(mysqlDialect); ok {
+		switch {
+		case db.multiTenant:
+			return nil, errMySQLUnsupported("MultiTenant")
+		case db.createdAt:
+			return nil, errMySQLUnsupported("CreatedAt")
+		case db.notifyChannel != "":
+			return nil, errMySQLUnsupported("NotifyChannel")
+		}
+	}
+	db.ensureTable = cfg.EnsureTable
+	db.logger = cfg.Logger
+	if db.logger == nil {
+		db.logger = noopLogger{}
+	}
+	db.slowQueryThreshold = cfg.SlowQueryThreshold
+	db.metrics = cfg.Metrics
+	db.tracer = cfg.Tracer
+	db.clock = cfg.Clock
+	if db.clock == nil {
+		db.clock = systemClock{}
+	}
+	db.retryMaxAttempts = cfg.RetryMaxAttempts
+	db.retryBaseDelay = cfg.RetryBaseDelay
+	db.statementTimeout = cfg.StatementTimeout
+	if cfg.CircuitBreakerThreshold > 0 {
+		cooldown := cfg.CircuitBreakerCooldown
+		if cooldown <= 0 {
+			cooldown = defaultCircuitBreakerCooldown
+		}
+		db.breaker = newCircuitBreaker(cfg.CircuitBreakerThreshold, cooldown)
+	}
+	db.opTimeouts = make(map[string]time.Duration, len(defaultOpTimeouts)+len(cfg.OperationTimeouts))
+	for op, timeout := range defaultOpTimeouts {
+		db.opTimeouts[op] = timeout
+	}
+	for op, timeout := range cfg.OperationTimeouts {
+		db.opTimeouts[op] = timeout
+	}
+	if len(cfg.SearchPath) > 0 {
+		if !isPostgresCompatible(db.dialect) {
+			return nil, errSearchPathUnsupported
+		}
+		db.searchPath = quoteSearchPath(cfg.SearchPath)
+	}
+	db.gcAdvisoryLock = cfg.GCAdvisoryLock
+	if _, ok := db.dialect.(postgresDialect); db.gcAdvisoryLock && !ok {
+		return nil, errGCAdvisoryLockUnsupported
+	}
+	db.gcJitter = cfg.GCJitter
+	db.gcBatchSize = cfg.GCBatchSize
+	db.partitioned = cfg.Partitioned
+	db.partitionRetention = cfg.PartitionRetention
+	if _, ok := db.dialect.(postgresDialect); db.partitioned && !ok {
+		return nil, errPartitionsUnsupported
+	}
+	db.onExpire = cfg.OnExpire
+
+	if cfg.CacheSize > 0 {
+		ttl := cfg.CacheTTL
+		if ttl == 0 {
+			ttl = defaultCacheTTL
+		}
+		db.cache = newRowCache(cfg.CacheSize, ttl)
+	}
+	db.buildQueries()
+
+	return db, nil
 }
 
-// update session by sessionID
-func (db *Dao) updateBySessionID(sessionID, contents []byte, lastActiveTime int64, expiration time.Duration) (int64, error) {
-	return db.Exec(db.sqlUpdateBySessionID, gotils.B2S(contents), lastActiveTime, expiration/time.Second, gotils.B2S(sessionID))
+// connect configure the pool of the opened connection, verifying it
+// and creating the table when enabled
+//
+// The connection is closed on error, unless it is managed by the caller
+func (db *Dao) connect(cfg DaoConfig) error {
+	return db.connectContext(context.Background(), cfg)
 }
 
-// delete session by sessionID
-func (db *Dao) deleteBySessionID(sessionID []byte) (int64, error) {
-	return db.Exec(db.sqlDeleteBySessionID, gotils.B2S(sessionID))
+// connectContext configure the pool of the opened connection,
+// verifying it and creating the table when enabled, bound to ctx
+func (db *Dao) connectContext(ctx context.Context, cfg DaoConfig) error {
+	db.configurePool(cfg)
+
+	if cfg.VerifyConnection {
+		if err := db.pingContext(ctx, cfg); err != nil {
+			db.closeConnection()
+			return err
+		}
+	}
+
+	if cfg.EnsureTable {
+		if err := db.EnsureTableContext(ctx); err != nil {
+			db.closeConnection()
+			return err
+		}
+	}
+
+	return nil
 }
 
-// delete session by expiration
-func (db *Dao) deleteExpiredSessions() (int64, error) {
-	return db.Exec(db.sqlDeleteExpiredSessions, time.Now().Unix())
+// pingContext verify the connection, retrying the lost connections with an exponential backoff
+// up to the connect retry attempts, like a database still starting up
+func (db *Dao) pingContext(ctx context.Context, cfg DaoConfig) error {
+	delay := cfg.ConnectRetryBaseDelay
+	if delay <= 0 {
+		delay = defaultConnectRetryDelay
+	}
+
+	err := db.Connection.PingContext(ctx)
+
+	for attempt := 1; attempt < cfg.ConnectRetryMaxAttempts && err != nil && isConnectionLost(err); attempt++ {
+		db.logger.Errorf("session connect attempt %d failed, retrying in %s: %v", attempt, delay, err)
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		if delay *= 2; delay > maxConnectRetryDelay {
+			delay = maxConnectRetryDelay
+		}
+
+		err = db.Connection.PingContext(ctx)
+	}
+
+	return err
 }
 
-// insert new session
-func (db *Dao) insert(sessionID, contents []byte, lastActiveTime int64, expiration time.Duration) (int64, error) {
-	return db.Exec(db.sqlInsert, gotils.B2S(sessionID), gotils.B2S(contents), lastActiveTime, expiration/time.Second)
+// closeConnection close the connection pool, unless it is managed by the caller
+func (db *Dao) closeConnection() error {
+	if db.sharedConnection {
+		return nil
+	}
+
+	return db.Connection.Close()
 }
 
-// insert new session
-func (db *Dao) regenerate(oldID, newID []byte, lastActiveTime int64, expiration time.Duration) (int64, error) {
-	return db.Exec(db.sqlRegenerate, gotils.B2S(newID), lastActiveTime, expiration/time.Second, gotils.B2S(oldID))
+// connectReader open the read replica connection with the same pool configuration
+func (db *Dao) connectReader(ctx context.Context, driver string, cfg DaoConfig) error {
+	conn, err := sql.Open(driver, db.withServerOptions(cfg.ReadDsn))
+	if err != nil {
+		return err
+	}
+
+	reader := &Dao{}
+	reader.Connection = conn
+	reader.logger = db.logger
+	if err = reader.connectContext(ctx, DaoConfig{
+		MaxOpenConns:            cfg.MaxOpenConns,
+		MaxIdleConns:            cfg.MaxIdleConns,
+		ConnMaxLifetime:         cfg.ConnMaxLifetime,
+		ConnMaxIdleTime:         cfg.ConnMaxIdleTime,
+		VerifyConnection:        cfg.VerifyConnection,
+		ConnectRetryMaxAttempts: cfg.ConnectRetryMaxAttempts,
+		ConnectRetryBaseDelay:   cfg.ConnectRetryBaseDelay,
+	}); err != nil {
+		return err
+	}
+
+	db.ReadConnection = conn
+	if !cfg.DisableStatementCache {
+		db.readStmts = &stmtCache{stmts: make(map[string]*sql.Stmt)}
+	}
+
+	return nil
+}
+
+// setTableName validate and split the table name
+func (db *Dao) setTableName(tableName string) error {
+	if tableName == "" {
+		return errTableNameEmpty
+	}
+
+	parts, err := parseQualifiedName(tableName)
+	if err != nil {
+		return err
+	}
+
+	db.tableName = tableName
+	db.tableParts = parts
+
+	return nil
+}
+
+// buildQueries build the sql statements for the table and column names
+//
+// The placeholders in the format strings are:
+// %[1]s table, %[2]s session_id, %[3]s contents, %[4]s last_active, %[5]s expiration,
+// %[6]s expires_at, %[7]s created_at, %[8]s tenant_id
+// and the $n bind parameters are rewritten to the ones of the dialect.
+// The {tenant} parameter is bound to the one after the last $n of the statement
+func (db *Dao) buildQueries() {
+	c := db.columns
+	db.quotedTableName = quoteQualifiedName(db.dialect, db.tableParts)
+	db.tenantQueries = nil
+	db.argOrders = nil
+	sqlf := func(format string) string {
+		query := format // a statement without names would get the unused ones appended
+		if strings.Contains(format, "%[") {
+			query = fmt.Sprintf(format, db.quotedTableName, c.SessionID, c.Contents, c.LastActive, c.Expiration, c.ExpiresAt, c.CreatedAt, c.TenantID)
+		}
+		if !strings.Contains(query, "{tenant}") {
+			return db.rebindQuery(query)
+		}
+
+		query = db.rebindQuery(strings.Replace(query, "{tenant}", "$"+strconv.Itoa(maxPlaceholder(query)+1), -1))
+		if db.tenantQueries == nil {
+			db.tenantQueries = make(map[string]bool)
+		}
+		db.tenantQueries[query] = true
+
+		return query
+	}
+
+	// conditions of the sessions alive and expired at the unix time of the now parameter,
+	// the expiration 0 being SessionNeverExpires
+	alive := "(%[5]s=0 OR %[4]s+%[5]s>{now})"
+	expired := "%[4]s+%[5]s<={now} AND %[5]s<>0"
+	conflictExpired := "%[1]s.%[5]s<>0 AND %[1]s.%[4]s+%[1]s.%[5]s<=excluded.%[4]s"
+	conflictReset := ""
+	extraColumns := ""
+
+	// columns of the scanned rows and of the inserts, and the optional ones carried by the copies
+	selectColumns := "%[2]s,%[3]s,%[4]s,%[5]s"
+	insertColumns := "%[2]s, %[3]s, %[4]s, %[5]s"
+	insertValues := "$1,$2,$3,$4"
+	insertFullValues := "$1,$2,$3,$4"
+	copyColumns := ""
+
+	// key columns and conditions of the multi-tenant mode, scoping the statements to the tenant
+	keyColumns := "%[2]s"
+	keyDefinition := "%[2]s VARCHAR(64) PRIMARY KEY NOT NULL"
+	keyConstraint := ""
+	tenantAnd := ""
+	tenantWhere := ""
+	if db.multiTenant {
+		keyColumns = "%[8]s,%[2]s"
+		keyDefinition = "%[8]s VARCHAR(64) NOT NULL DEFAULT '', %[2]s VARCHAR(64) NOT NULL"
+		keyConstraint = ", PRIMARY KEY (%[8]s, %[2]s)"
+		tenantAnd = " AND %[8]s={tenant}"
+		tenantWhere = " WHERE %[8]s={tenant}"
+		insertColumns += ", %[8]s"
+		insertValues += ",{tenant}"
+		insertFullValues += ",{tenant}"
+		copyColumns += ",%[8]s"
+	}
+
+	// the primary key of a partitioned table must hold its partition key, the last activity
+	partitionBy := ""
+	if db.partitioned {
+		if !db.multiTenant {
+			keyDefinition = "%[2]s VARCHAR(64) NOT NULL"
+		}
+		keyConstraint = ", PRIMARY KEY (" + keyColumns + ", %[4]s)"
+		partitionBy = " PARTITION BY RANGE (%[4]s)"
+	}
+
+	if db.expiresAt {
+		alive += " AND (%[6]s=0 OR %[6]s>{now})"
+		expired = "(" + expired + ") OR (%[6]s<>0 AND %[6]s<={now})"
+		conflictExpired = "(" + conflictExpired + ") OR (%[1]s.%[6]s<>0 AND %[1]s.%[6]s<=excluded.%[4]s)"
+		selectColumns += ",%[6]s"
+		conflictReset += ",%[6]s=0"
+		extraColumns += ", %[6]s BIGINT NOT NULL DEFAULT 0"
+		copyColumns += ",%[6]s"
+	}
+	if db.createdAt {
+		selectColumns += ",%[7]s"
+		insertColumns += ", %[7]s"
+		insertValues += ",$3"
+		insertFullValues += ",$5"
+		conflictReset += ",%[7]s=excluded.%[7]s"
+		extraColumns += ", %[7]s BIGINT NOT NULL DEFAULT 0"
+		copyColumns += ",%[7]s"
+	}
+	// the batch inserts write the deadline and the creation time of the rows, like the copied ones
+	batchColumns := "%[2]s, %[3]s, %[4]s, %[5]s"
+	batchValues := "$1,$2,$3,$4"
+	db.insertBatchParams = 4
+	if db.multiTenant {
+		batchColumns += ", %[8]s"
+		batchValues += ",{tenant}"
+	}
+	if db.expiresAt {
+		db.insertBatchParams++
+		batchColumns += ", %[6]s"
+		batchValues += ",$" + strconv.Itoa(db.insertBatchParams)
+	}
+	if db.createdAt {
+		db.insertBatchParams++
+		batchColumns += ", %[7]s"
+		batchValues += ",$" + strconv.Itoa(db.insertBatchParams)
+	}
+
+	// LIMIT of a query bounded by its OFFSET only, sqlite and mysql having no LIMIT ALL
+	_, mysql := db.dialect.(mysqlDialect)
+	unlimited := "ALL"
+	if _, ok := db.dialect.(sqliteDialect); ok {
+		unlimited = "-1"
+	} else if mysql {
+		unlimited = "18446744073709551615"
+	}
+	at := func(cond, now string) string {
+		return strings.Replace(cond, "{now}", now, -1)
+	}
+	indexName := db.dialect.QuoteIdentifier(db.tableParts[len(db.tableParts)-1] + "_" + c.LastActive + "_idx")
+
+	db.sqlGetSessionBySessionID = sqlf("SELECT " + selectColumns + " FROM %[1]s WHERE %[2]s=$1 AND " + at(alive, "$2") + tenantAnd)
+	db.sqlListSessions = sqlf("SELECT " + selectColumns + " FROM %[1]s WHERE " + at(alive, "$3") + tenantAnd + " ORDER BY %[4]s DESC LIMIT $1 OFFSET $2")
+	db.sqlGetBySessionIDs = sqlf("SELECT " + selectColumns + " FROM %[1]s WHERE %[2]s=ANY($1) AND " + at(alive, "$2") + tenantAnd)
+	db.sqlListAfter = sqlf("SELECT " + selectColumns + " FROM %[1]s WHERE %[2]s>$1 AND " + at(alive, "$2") + tenantAnd + " ORDER BY %[2]s LIMIT $3")
+	db.sqlListIdleBefore = sqlf("SELECT " + selectColumns + " FROM %[1]s WHERE %[4]s<$1" + tenantAnd + " ORDER BY %[4]s LIMIT $2")
+	db.sqlListExpiringWithin = sqlf("SELECT " + selectColumns + " FROM %[1]s WHERE %[5]s<>0 AND %[4]s+%[5]s<=$2 AND " + at(alive, "$1") + tenantAnd + " ORDER BY %[4]s+%[5]s LIMIT $3")
+	db.sqlIterate = sqlf("SELECT " + selectColumns + " FROM %[1]s" + tenantWhere)
+	db.sqlCountSessions = sqlf("SELECT count(*) as total FROM %[1]s" + tenantWhere)
+	db.sqlApproxCountSessions = sqlf("SELECT reltuples::bigint FROM pg_class WHERE oid=to_regclass($1)")
+	db.sqlCountByExpirationBucket = sqlf("SELECT CASE WHEN %[5]s=0 THEN 0 {buckets}ELSE -1 END AS bucket, count(*) FROM %[1]s WHERE " + at(alive, "$1") + tenantAnd + " GROUP BY bucket")
+	db.sqlCountExpiredSessions = sqlf("SELECT count(*) as total FROM %[1]s WHERE " + at(expired, "$1"))
+	db.sqlCountActiveSessions = sqlf("SELECT count(*) as total FROM %[1]s WHERE " + at(alive, "$1") + tenantAnd)
+	db.sqlUpdateBySessionID = sqlf("UPDATE %[1]s SET %[3]s=$1,%[4]s=$2,%[5]s=$3 WHERE %[2]s=$4" + tenantAnd)
+	db.sqlUpdateIfLastActive = sqlf("UPDATE %[1]s SET %[3]s=$1,%[4]s=$2,%[5]s=$3 WHERE %[2]s=$4 AND %[4]s=$5" + tenantAnd)
+	db.sqlGetAndTouch = sqlf("UPDATE %[1]s SET %[4]s=$1 WHERE %[2]s=$2 AND " + at(alive, "$1") + tenantAnd + " RETURNING " + selectColumns)
+	db.sqlTouchAlive = sqlf("UPDATE %[1]s SET %[4]s=$1 WHERE %[2]s=$2 AND " + at(alive, "$1") + tenantAnd)
+	db.sqlUpdateExpiration = sqlf("UPDATE %[1]s SET %[5]s=$1 WHERE %[2]s=$2" + tenantAnd)
+	db.sqlExtendAll = sqlf("UPDATE %[1]s SET %[5]s=%[5]s+$1 WHERE %[5]s<>0 AND " + at(alive, "$2") + tenantAnd)
+	db.sqlUpdateContents = sqlf("UPDATE %[1]s SET %[3]s=$1 WHERE %[2]s=$2" + tenantAnd)
+	db.sqlTouch = sqlf("UPDATE %[1]s SET %[4]s=$1 WHERE %[2]s=$2" + tenantAnd)
+	db.sqlDeleteBySessionID = sqlf("DELETE FROM %[1]s WHERE %[2]s=$1" + tenantAnd)
+	db.sqlDeleteAndReturn = sqlf("DELETE FROM %[1]s WHERE %[2]s=$1" + tenantAnd + " RETURNING " + selectColumns)
+	db.sqlGetStoredBySessionID = sqlf("SELECT " + selectColumns + " FROM %[1]s WHERE %[2]s=$1" + tenantAnd)
+	db.sqlDeleteBySessionIDs = sqlf("DELETE FROM %[1]s WHERE %[2]s=ANY($1)" + tenantAnd)
+	db.sqlDeleteBySessionIDsIn = sqlf("DELETE FROM %[1]s WHERE %[2]s IN ({ids})" + tenantAnd)
+	db.sqlDeleteByJSONField = sqlf("DELETE FROM %[1]s WHERE %[3]s->>$1=$2" + tenantAnd)
+	db.sqlDeleteByContentsLike = sqlf("DELETE FROM %[1]s WHERE %[3]s LIKE $1 ESCAPE '\\'" + tenantAnd)
+	db.sqlDeleteAll = sqlf("DELETE FROM %[1]s" + tenantWhere)
+	db.sqlTruncate = sqlf("TRUNCATE TABLE %[1]s")
+	db.sqlDeleteExpiredSessions = sqlf("DELETE FROM %[1]s WHERE " + at(expired, "$1"))
+	db.sqlDeleteExpiredReturning = sqlf("DELETE FROM %[1]s WHERE " + at(expired, "$1") + " RETURNING " + selectColumns)
+	db.sqlListExpiredSessions = sqlf("SELECT " + selectColumns + " FROM %[1]s WHERE " + at(expired, "$1"))
+	// mysql neither limits an IN subquery nor reads the table a DELETE writes,
+	// but deletes with a LIMIT and reads a derived table
+	if mysql {
+		db.sqlEnforceMaxSessions = sqlf("DELETE FROM %[1]s WHERE (" + keyColumns + ") IN (SELECT " + keyColumns + " FROM (SELECT " + keyColumns + " FROM %[1]s" + tenantWhere + " ORDER BY %[4]s DESC, %[2]s DESC LIMIT " + unlimited + " OFFSET $1) AS evicted)")
+		db.sqlDeleteExpiredSessionsBatch = sqlf("DELETE FROM %[1]s WHERE " + at(expired, "$1") + " LIMIT $2")
+	} else {
+		db.sqlEnforceMaxSessions = sqlf("DELETE FROM %[1]s WHERE (" + keyColumns + ") IN (SELECT " + keyColumns + " FROM %[1]s" + tenantWhere + " ORDER BY %[4]s DESC, %[2]s DESC LIMIT " + unlimited + " OFFSET $1)")
+		db.sqlDeleteExpiredSessionsBatch = sqlf("DELETE FROM %[1]s WHERE (" + keyColumns + ") IN (SELECT " + keyColumns + " FROM %[1]s WHERE " + at(expired, "$1") + " LIMIT $2)")
+	}
+	db.sqlInsert = sqlf("INSERT INTO %[1]s (" + insertColumns + ") VALUES (" + insertValues + ")")
+	db.sqlInsertFull = sqlf("INSERT INTO %[1]s (" + insertColumns + ") VALUES (" + insertFullValues + ")")
+	db.sqlInsertBatch = sqlf("INSERT INTO %[1]s (" + batchColumns + ") VALUES ")
+	db.insertBatchValues = "(" + batchValues + ")"
+	db.sqlInsertIfNotExists = sqlf("INSERT INTO %[1]s (" + insertColumns + ") VALUES (" + insertValues + ") ON CONFLICT (" + keyColumns + ") DO UPDATE SET %[3]s=excluded.%[3]s,%[4]s=excluded.%[4]s,%[5]s=excluded.%[5]s" + conflictReset + " WHERE " + conflictExpired + " RETURNING " + selectColumns)
+	// mysql upserts on any duplicate key, the session id being its only one
+	if mysql {
+		db.sqlSave = sqlf("INSERT INTO %[1]s (" + insertColumns + ") VALUES (" + insertValues + ") ON DUPLICATE KEY UPDATE %[3]s=VALUES(%[3]s),%[4]s=VALUES(%[4]s),%[5]s=VALUES(%[5]s)")
+	} else {
+		db.sqlSave = sqlf("INSERT INTO %[1]s (" + insertColumns + ") VALUES (" + insertValues + ") ON CONFLICT (" + keyColumns + ") DO UPDATE SET %[3]s=excluded.%[3]s,%[4]s=excluded.%[4]s,%[5]s=excluded.%[5]s")
+	}
+	db.sqlRegenerate = sqlf("UPDATE %[1]s SET %[2]s=$1,%[4]s=$2,%[5]s=$3 WHERE %[2]s=$4" + tenantAnd)
+	db.sqlRegenerateCopy = sqlf("INSERT INTO %[1]s (%[2]s, %[3]s, %[4]s, %[5]s" + copyColumns + ") SELECT $1,%[3]s,$2,$3" + copyColumns + " FROM %[1]s WHERE %[2]s=$4" + tenantAnd)
+	db.sqlRegenerateWithContents = sqlf("UPDATE %[1]s SET %[2]s=$1,%[3]s=$2,%[4]s=$3,%[5]s=$4 WHERE %[2]s=$5" + tenantAnd)
+	db.sqlRegenerateWithContentsCopy = sqlf("INSERT INTO %[1]s (%[2]s, %[3]s, %[4]s, %[5]s" + copyColumns + ") SELECT $1,$2,$3,$4" + copyColumns + " FROM %[1]s WHERE %[2]s=$5" + tenantAnd)
+	db.sqlRegenerateKeepContents = sqlf("INSERT INTO %[1]s (%[2]s, %[3]s, %[4]s, %[5]s" + copyColumns + ") SELECT $1,%[3]s,%[4]s,%[5]s" + copyColumns + " FROM %[1]s WHERE %[2]s=$2" + tenantAnd)
+	db.sqlExpireAt = sqlf("UPDATE %[1]s SET %[6]s=$1 WHERE %[2]s=$2" + tenantAnd)
+	db.sqlFindByJSONField = sqlf("SELECT " + selectColumns + " FROM %[1]s WHERE %[3]s->>$1=$2" + tenantAnd)
+	db.sqlConsumeUse = sqlf("UPDATE %[1]s SET %[3]s=jsonb_set(%[3]s,'{uses}',to_jsonb((%[3]s->>'uses')::int-1)) WHERE %[2]s=$1 AND (%[3]s->>'uses')::int>0 AND " + at(alive, "$2") + tenantAnd + " RETURNING (%[3]s->>'uses')::int")
+
+	// size in bytes of the stored contents, sqlite length counting the characters of a text
+	contentsLength := "octet_length(%[3]s)"
+	if _, ok := db.dialect.(sqliteDialect); ok {
+		contentsLength = "length(CAST(%[3]s AS BLOB))"
+	} else if db.jsonContents {
+		contentsLength = "octet_length(%[3]s::text)"
+	}
+	db.sqlTotalContentsBytes = sqlf("SELECT coalesce(sum(" + contentsLength + "),0) FROM %[1]s" + tenantWhere)
+	db.sqlTotalContentsBytesByTenant = sqlf("SELECT %[8]s, coalesce(sum(" + contentsLength + "),0) FROM %[1]s GROUP BY %[8]s")
+
+	// mysql has neither a default of the text and blob columns nor a CREATE INDEX IF NOT EXISTS,
+	// its index is declared by the table
+	contentsType := "TEXT NOT NULL DEFAULT ''"
+	if mysql {
+		contentsType = "TEXT NOT NULL"
+		if db.jsonContents {
+			contentsType = "JSON NOT NULL"
+		} else if db.binaryContents {
+			contentsType = "LONGBLOB NOT NULL"
+		}
+		keyConstraint += ", INDEX " + indexName + " (%[4]s)"
+	} else if db.jsonContents {
+		contentsType = "JSONB NOT NULL DEFAULT '{}'"
+	} else if db.binaryContents {
+		contentsType = "BYTEA NOT NULL DEFAULT ''"
+	}
+	db.sqlNotify = sqlf("SELECT pg_notify($1, $2)")
+	db.sqlNotifyIDs = sqlf("SELECT pg_notify($1, id) FROM unnest($2::text[]) AS id")
+	db.sqlHealthCheck = sqlf("SELECT 1 FROM %[1]s LIMIT 1")
+	db.sqlTryGCLock = sqlf("SELECT pg_try_advisory_xact_lock($1)")
+	db.gcLockKey = advisoryLockKey(db.quotedTableName)
+	db.sqlCreateTable = sqlf("CREATE TABLE IF NOT EXISTS %[1]s (" + keyDefinition + ", %[3]s " + contentsType + ", %[4]s BIGINT NOT NULL DEFAULT 0, %[5]s BIGINT NOT NULL DEFAULT 0" + extraColumns + keyConstraint + ")" + partitionBy)
+	db.sqlLockSessionID = sqlf("SELECT pg_advisory_xact_lock(hashtext($1), hashtext($2))")
+	db.sqlListPartitions = sqlf("SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid=i.inhrelid WHERE i.inhparent=to_regclass($1)")
+	db.sqlCreateIndex = ""
+	if !mysql {
+		db.sqlCreateIndex = sqlf("CREATE INDEX IF NOT EXISTS " + indexName + " ON %[1]s (%[4]s)")
+	}
+}
+
+// rebindQuery rewrite the $n placeholders of the statement to the ones of the dialect,
+// recording the order of its arguments when a positional dialect binds them otherwise
+func (db *Dao) rebindQuery(query string) string {
+	if !isPositional(db.dialect) {
+		return rebind(db.dialect, query)
+	}
+
+	query, order := rebindOrder(db.dialect, query)
+	if !isSequential(order) {
+		if db.argOrders == nil {
+			db.argOrders = make(map[string][]int)
+		}
+		db.argOrders[query] = order
+	}
+
+	return query
+}
+
+// configurePool apply the non-zero pool settings to the connection
+func (db *Dao) configurePool(cfg DaoConfig) {
+	if cfg.MaxOpenConns != 0 {
+		db.Connection.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns != 0 {
+		db.Connection.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime != 0 {
+		db.Connection.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.ConnMaxIdleTime != 0 {
+		setConnMaxIdleTime(db.Connection, cfg.ConnMaxIdleTime)
+	}
+}
+
+// EnsureTable create the session table and its last_active index when they do not exist
+//
+// It is idempotent and safe to call on every startup
+func (db *Dao) EnsureTable() error {
+	return db.EnsureTableContext(context.Background())
+}
+
+// EnsureTableContext create the session table and its last_active index when they do not exist,
+// aborting the in-flight statements when ctx is cancelled
+func (db *Dao) EnsureTableContext(ctx context.Context) error {
+	if _, err := db.Connection.ExecContext(ctx, db.sqlCreateTable); err != nil {
+		return err
+	}
+	if db.sqlCreateIndex == "" {
+		return nil
+	}
+
+	_, err := db.Connection.ExecContext(ctx, db.sqlCreateIndex)
+
+	return err
+}
+
+// HealthCheck check the database is reachable and the session table is readable,
+// respecting the ctx deadline
+//
+// The read replica is checked too when configured
+func (db *Dao) HealthCheck(ctx context.Context) error {
+	conns := []*sql.DB{db.Connection}
+	if db.ReadConnection != nil {
+		conns = append(conns, db.ReadConnection)
+	}
+
+	for _, conn := range conns {
+		if err := conn.PingContext(ctx); err != nil {
+			return err
+		}
+
+		var one int
+		err := conn.QueryRowContext(ctx, db.sqlHealthCheck).Scan(&one)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stats return the statistics of the primary connection pool,
+// like the open, in use and idle connections and the waits for one
+//
+// The pool is the Connection of the embedded session.Dao,
+// the read replica pool reports its own through ReadConnection.Stats
+func (db *Dao) Stats() sql.DBStats {
+	return db.Connection.Stats()
+}
+
+// SetLogger set the logger of the slow queries and the errors
+//
+// It must be called before using the Dao
+func (db *Dao) SetLogger(logger Logger) {
+	if logger == nil {
+		logger = noopLogger{}
+	}
+
+	db.logger = logger
+}
+
+func (noopLogger) Debugf(format string, args ...interface{}) {}
+
+func (noopLogger) Errorf(format string, args ...interface{}) {}
+
+// Close stop the garbage collector and close the database connection pool
+//
+// It is safe to call it multiple times, the subsequent calls return nil
+func (db *Dao) Close() error {
+	if db.Connection == nil || !atomic.CompareAndSwapUint32(&db.closed, 0, 1) {
+		return nil
+	}
+
+	db.StopGC()
+
+	if db.stmts != nil {
+		db.stmts.close()
+	}
+
+	if db.ReadConnection != nil {
+		if db.readStmts != nil {
+			db.readStmts.close()
+		}
+		db.ReadConnection.Close()
+	}
+
+	return db.closeConnection()
+}
+
+// WithTx run fn in a transaction, handing it a Dao whose queries run inside it
+//
+// The transaction is committed when fn returns nil and rolled back otherwise.
+// The queries of the transaction scoped Dao are not retried, since a failed
+// statement aborts the transaction, and they never go to the read replica.
+// Closing it is a no-op and it must not be used after fn returns.
+// Called on a transaction scoped Dao, fn runs in the ongoing transaction
+func (db *Dao) WithTx(ctx context.Context, fn func(tx *Dao) error) error {
+	if db.tx != nil {
+		return fn(db)
+	}
+
+	tx, err := db.Connection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if db.Dsn == "" && db.statementTimeout > 0 && isPostgresCompatible(db.dialect) {
+		if _, err = tx.ExecContext(ctx, "SET LOCAL statement_timeout = "+statementTimeoutMillis(db.statementTimeout)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if db.sharedConnection && db.searchPath != "" {
+		if _, err = tx.ExecContext(ctx, "SET LOCAL search_path TO "+db.searchPath); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	txDao := *db
+	txDao.tx = tx
+	txDao.closed = 1
+	txDao.gc = nil
+	txDao.retryMaxAttempts = 1
+
+	if err = fn(&txDao); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Exec executes a custom query on the primary connection, like an insert,
+// an update or a delete, and returns the affected rows reported by the driver,
+// never the last insert id which postgres does not support
+//
+// It runs inside the transaction when the Dao is transaction scoped.
+// The query is neither retried, logged nor measured, and the errors
+// of database/sql and of the driver, like *pq.Error, are returned unwrapped
+func (db *Dao) Exec(query string, args ...interface{}) (int64, error) {
+	return db.ExecContext(context.Background(), query, args...)
+}
+
+// ExecContext executes a custom query like Exec, bound to ctx
+func (db *Dao) ExecContext(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	var res sql.Result
+	var err error
+
+	if db.tx != nil {
+		res, err = db.tx.ExecContext(ctx, query, args...)
+	} else {
+		res, err = db.Connection.ExecContext(ctx, query, args...)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Query executes a custom query on the primary connection returning rows,
+// that the caller must close
+//
+// It runs inside the transaction when the Dao is transaction scoped.
+// Like Exec, the query is neither retried, logged nor measured
+// and the errors are returned unwrapped
+func (db *Dao) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext executes a custom query like Query, bound to ctx
+func (db *Dao) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if db.tx != nil {
+		return db.tx.QueryContext(ctx, query, args...)
+	}
+
+	return db.Connection.QueryContext(ctx, query, args...)
+}
+
+// QueryRow executes a custom query on the primary connection
+// that is expected to return at most one row
+//
+// The returned error is always nil: as database/sql does, the errors
+// of the query are deferred to the Scan of the row, which returns
+// sql.ErrNoRows when there is none. It runs inside the transaction
+// when the Dao is transaction scoped
+func (db *Dao) QueryRow(query string, args ...interface{}) (*sql.Row, error) {
+	return db.QueryRowContext(context.Background(), query, args...)
+}
+
+// QueryRowContext executes a custom query like QueryRow, bound to ctx
+func (db *Dao) QueryRowContext(ctx context.Context, query string, args ...interface{}) (*sql.Row, error) {
+	if db.tx != nil {
+		return db.tx.QueryRowContext(ctx, query, args...), nil
+	}
+
+	return db.Connection.QueryRowContext(ctx, query, args...), nil
+}
+
+// prepareContext return the cached prepared statement of the query on conn,
+// preparing it on first use
+//
+// database/sql transparently re-prepares the statement on every new
+// connection of the pool, so it keeps working after a reconnection
+func (c *stmtCache) prepareContext(ctx context.Context, conn *sql.DB, query string) (*sql.Stmt, error) {
+	c.mu.RLock()
+	stmt := c.stmts[query]
+	c.mu.RUnlock()
+
+	if stmt != nil {
+		return stmt, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if stmt = c.stmts[query]; stmt != nil {
+		return stmt, nil
+	}
+
+	stmt, err := conn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	c.stmts[query] = stmt
+
+	return stmt, nil
+}
+
+// lookup return the cached prepared statement of the query, nil when it is not prepared yet
+func (c *stmtCache) lookup(query string) *sql.Stmt {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.stmts[query]
+}
+
+// close close all the cached prepared statements
+func (c *stmtCache) close() {
+	c.mu.Lock()
+	for query, stmt := range c.stmts {
+		stmt.Close()
+		delete(c.stmts, query)
+	}
+	c.mu.Unlock()
+}
+
+// WithPrimary return a copy of ctx that routes the read only queries to the primary,
+// to read your own writes while the read replica lags behind
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryContextKey{}, true)
+}
+
+func isPrimaryRequired(ctx context.Context) bool {
+	required, _ := ctx.Value(primaryContextKey{}).(bool)
+	return required
+}
+
+// reader return the connection and statements cache to run the read only queries bound to ctx,
+// which is the read replica when configured, out of a transaction, and ctx does not require the primary
+func (db *Dao) reader(ctx context.Context) (*sql.DB, *stmtCache) {
+	if db.ReadConnection == nil || db.tx != nil || isPrimaryRequired(ctx) {
+		return db.Connection, db.stmts
+	}
+
+	return db.ReadConnection, db.readStmts
+}
+
+// run run fn, the execution of the query of the operation op, retrying its transient failures,
+// logging the errors and the queries slower than the threshold and observing its metrics
+//
+// The errors are wrapped as "session <op>: <err>", errors.Is and errors.As still
+// match the underlying error of the driver, like *pq.Error. The session id is left out, it is a credential.
+// sql.ErrNoRows is returned as is
+func (db *Dao) run(ctx context.Context, op, query string, fn func() error) error {
+	if err := db.checkCircuit(ctx); err != nil {
+		return fmt.Errorf("session %s: %w", op, err)
+	}
+
+	if db.monitor != nil && atomic.LoadUint32(&db.monitor.lost) == 1 {
+		db.recoverConnection(ctx)
+	}
+
+	var endSpan func(error)
+	if db.tracer != nil {
+		endSpan = db.tracer.StartSpan(ctx, "session."+op, map[string]string{
+			"db.operation": op,
+			"db.sql.table": db.tableName,
+			"db.statement": query,
+		})
+	}
+
+	start := time.Now()
+	err := db.retry(ctx, fn)
+	if isTableMissing(err) {
+		err = db.recoverTable(ctx, fn, err)
+	}
+	elapsed := time.Since(start)
+
+	if db.monitor != nil && isConnectionLost(err) {
+		atomic.StoreUint32(&db.monitor.lost, 1)
+	}
+	db.recordCircuit(err)
+
+	failure := err
+	if failure == sql.ErrNoRows {
+		failure = nil
+	}
+
+	if failure != nil {
+		db.logger.Errorf("session %s failed after %s: %v", op, elapsed, err)
+	} else if db.slowQueryThreshold > 0 && elapsed >= db.slowQueryThreshold {
+		db.logger.Debugf("session %s slow query took %s: %s", op, elapsed, query)
+	}
+
+	if db.metrics != nil {
+		db.metrics.ObserveQuery(op, elapsed, failure)
+	}
+	if endSpan != nil {
+		endSpan(failure)
+	}
+
+	if failure != nil {
+		return fmt.Errorf("session %s: %w", op, err)
+	}
+
+	return err
+}
+
+// withOpTimeout bound ctx to the timeout of the operation, when it has one,
+// the earlier deadline of ctx still applying
+func (db *Dao) withOpTimeout(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	if timeout := db.opTimeouts[op]; timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+
+	return ctx, noopCancel
+}
+
+func noopCancel() {}
+
+// recoverTable recreate the dropped table and retry fn once when EnsureTable is enabled,
+// returning ErrTableMissing when the table is still missing
+//
+// Inside a transaction the failed statement aborted it, so the table is not recreated
+func (db *Dao) recoverTable(ctx context.Context, fn func() error, err error) error {
+	if db.ensureTable && db.tx == nil {
+		db.logger.Errorf("session table %s is missing, recreating it", db.tableName)
+
+		if ensureErr := db.EnsureTableContext(ctx); ensureErr != nil {
+			return fmt.Errorf("%w %s: %v", ErrTableMissing, db.tableName, ensureErr)
+		}
+
+		if err = fn(); !isTableMissing(err) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("%w %s: %v", ErrTableMissing, db.tableName, err)
+}
+
+// execContext executes the query bound to ctx and returns the affected rows
+//
+// The transient failures are retried
+func (db *Dao) execContext(ctx context.Context, op, query string, args ...interface{}) (int64, error) {
+	return db.execOn(ctx, op, db.stmts, query, args...)
+}
+
+// execUnpreparedContext executes the query bound to ctx like execContext, without preparing it,
+// for the queries built per call which would pile up in the statements cache
+func (db *Dao) execUnpreparedContext(ctx context.Context, op, query string, args ...interface{}) (int64, error) {
+	return db.execOn(ctx, op, nil, query, args...)
+}
+
+func (db *Dao) execOn(ctx context.Context, op string, stmts *stmtCache, query string, args ...interface{}) (int64, error) {
+	var n int64
+	args = db.bindArgs(query, args)
+
+	err := db.run(ctx, op, query, func() error {
+		var err error
+		n, err = db.execOnce(ctx, stmts, query, args...)
+
+		return err
+	})
+
+	return n, err
+}
+
+// execOnce executes the query with its bound arguments once and returns the affected rows,
+// for the statements of a transaction whose retries wrap the whole transaction
+func (db *Dao) execOnce(ctx context.Context, stmts *stmtCache, query string, args ...interface{}) (int64, error) {
+	var res sql.Result
+	var err error
+
+	if stmts != nil {
+		var stmt *sql.Stmt
+		if stmt, err = db.prepareContext(ctx, db.Connection, stmts, query); err != nil {
+			return 0, err
+		}
+		res, err = stmt.ExecContext(ctx, args...)
+	} else if db.tx != nil {
+		res, err = db.tx.ExecContext(ctx, query, args...)
+	} else {
+		res, err = db.Connection.ExecContext(ctx, query, args...)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// bindArgs return the arguments of the query, appending the tenant id of the tenant scoped ones
+// and reordering them to the placeholders of a positional dialect
+func (db *Dao) bindArgs(query string, args []interface{}) []interface{} {
+	args = db.tenantArgs(query, args)
+
+	if order := db.argOrders[query]; order != nil {
+		return reorderArgs(order, args)
+	}
+
+	return args
+}
+
+// queryRowContext executes on the primary the query bound to ctx that is expected to return at most one row
+func (db *Dao) queryRowContext(ctx context.Context, query string, args ...interface{}) (*sql.Row, error) {
+	return db.queryRowOn(ctx, db.Connection, db.stmts, query, args...)
+}
+
+// readRowContext executes on the reader the query bound to ctx that is expected to return at most one row
+func (db *Dao) readRowContext(ctx context.Context, query string, args ...interface{}) (*sql.Row, error) {
+	conn, stmts := db.reader(ctx)
+
+	return db.queryRowOn(ctx, conn, stmts, query, args...)
+}
+
+// queryContext executes on the primary the query bound to ctx that returns rows
+func (db *Dao) queryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.queryOn(ctx, db.Connection, db.stmts, query, args...)
+}
+
+// readContext executes on the reader the query bound to ctx that returns rows
+func (db *Dao) readContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	conn, stmts := db.reader(ctx)
+
+	return db.queryOn(ctx, conn, stmts, query, args...)
+}
+
+// readUnpreparedContext executes on the reader the query bound to ctx like readContext, without preparing it,
+// for the queries built per call which would pile up in the statements cache
+func (db *Dao) readUnpreparedContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	conn, _ := db.reader(ctx)
+
+	return db.queryOn(ctx, conn, nil, query, args...)
+}
+
+func (db *Dao) queryRowOn(ctx context.Context, conn *sql.DB, stmts *stmtCache, query string, args ...interface{}) (*sql.Row, error) {
+	args = db.bindArgs(query, args)
+
+	if stmts == nil {
+		if db.tx != nil {
+			return db.tx.QueryRowContext(ctx, query, args...), nil
+		}
+		return conn.QueryRowContext(ctx, query, args...), nil
+	}
+
+	stmt, err := db.prepareContext(ctx, conn, stmts, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return stmt.QueryRowContext(ctx, args...), nil
+}
+
+func (db *Dao) queryOn(ctx context.Context, conn *sql.DB, stmts *stmtCache, query string, args ...interface{}) (*sql.Rows, error) {
+	args = db.bindArgs(query, args)
+
+	if stmts == nil {
+		if db.tx != nil {
+			return db.tx.QueryContext(ctx, query, args...)
+		}
+		return conn.QueryContext(ctx, query, args...)
+	}
+
+	stmt, err := db.prepareContext(ctx, conn, stmts, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return stmt.QueryContext(ctx, args...)
+}
+
+// prepareContext return the cached prepared statement of the query on conn,
+// bound to the transaction when the Dao is transaction scoped
+//
+// The transaction specific statement is closed by the commit or the rollback
+func (db *Dao) prepareContext(ctx context.Context, conn *sql.DB, stmts *stmtCache, query string) (*sql.Stmt, error) {
+	if db.tx == nil {
+		return stmts.prepareContext(ctx, conn, query)
+	}
+
+	// Preparing on the pool would need another connection than the one of the transaction
+	if stmt := stmts.lookup(query); stmt != nil {
+		return db.tx.StmtContext(ctx, stmt), nil
+	}
+
+	return db.tx.PrepareContext(ctx, query)
+}
+
+// now return the current time in the time unit of the Dao
+func (db *Dao) now() int64 {
+	return db.unixTime(db.clock.Now())
+}
+
+// unixTime return the unix time of t in the time unit of the Dao, 0 for the zero time
+//
+// All the time columns are stored in this unit, written and compared by the Dao only
+func (db *Dao) unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano() / int64(db.timeUnit)
+}
+
+// fromUnixTime return the time of a unix time in the time unit of the Dao, the zero time for 0
+func (db *Dao) fromUnixTime(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, n*int64(db.timeUnit))
+}
+
+// units return the duration in the time unit of the Dao, rounded up
+// so a short expiration never becomes 0, meaning SessionNeverExpires
+//
+// The negative durations, like the -1 of the browser session cookies, never expire either
+func (db *Dao) units(d time.Duration) int64 {
+	if d <= SessionNeverExpires {
+		return 0
+	}
+
+	n := d / db.timeUnit
+	if d%db.timeUnit > 0 {
+		n++
+	}
+
+	return int64(n)
+}
+
+// scanDBRow scan the session_id, contents, last_active, expiration and the optional created_at columns into data
+//
+// The contents are scanned into a ContentsValue of the configuration when it has one
+func (db *Dao) scanDBRow(row rowScanner, data *DBRow) error {
+	var contents interface{} = &data.contents
+	var value ContentsValue
+	if db.contentsValue != nil {
+		value = db.contentsValue()
+		contents = value
+	}
+
+	var lastActive, expiresAt, createdAt int64
+	dest := append(make([]interface{}, 0, 6), &data.sessionID, contents, &lastActive, &data.expiration)
+	if db.expiresAt {
+		dest = append(dest, &expiresAt)
+	}
+	if db.createdAt {
+		dest = append(dest, &createdAt)
+	}
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return err
+	}
+	if value != nil {
+		data.contents = value.Contents()
+	}
+	data.lastActive = db.fromUnixTime(lastActive)
+	data.expiresAt = db.fromUnixTime(expiresAt)
+	data.createdAt = db.fromUnixTime(createdAt)
+	data.expiration *= db.timeUnit
+
+	data.contents, err = db.decodeContents(data.contents)
+
+	return err
+}
+
+// fetchDBRow scan the result of a single row query into a pooled row,
+// which is left empty when there are no rows
+//
+// The transient failures of the query and the scan are retried
+func (db *Dao) fetchDBRow(ctx context.Context, op, sqlQuery string, query func() (*sql.Row, error)) (*DBRow, error) {
+	data := acquireDBRow()
+
+	err := db.run(ctx, op, sqlQuery, func() error {
+		row, err := query()
+		if err != nil {
+			return err
+		}
+
+		data.Reset()
+
+		return db.scanDBRow(row, data)
+	})
+	if err != nil && err != sql.ErrNoRows {
+		releaseDBRow(data)
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// fetchDBRows scan all the rows of the query into new rows owned by the caller
+//
+// The transient failures of the query and the scan are retried
+func (db *Dao) fetchDBRows(ctx context.Context, op, sqlQuery string, query func() (*sql.Rows, error)) ([]*DBRow, error) {
+	var result []*DBRow
+
+	err := db.run(ctx, op, sqlQuery, func() error {
+		rows, err := query()
+		if err != nil {
+			return err
+		}
+
+		result, err = db.scanDBRows(rows)
+
+		return err
+	})
+
+	return result, err
+}
+
+// scanDBRows scan all the rows into new rows owned by the caller
+func (db *Dao) scanDBRows(rows *sql.Rows) ([]*DBRow, error) {
+	defer rows.Close()
+
+	var result []*DBRow
+	for rows.Next() {
+		data := new(DBRow)
+		if err := db.scanDBRow(rows, data); err != nil {
+			return nil, err
+		}
+		result = append(result, data)
+	}
+
+	return result, rows.Err()
+}
+
+// foundDBRow return the row of a session lookup, or ErrSessionNotFound when it is empty
+func foundDBRow(row *DBRow, err error) (*DBRow, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	if row.sessionID == "" {
+		releaseDBRow(row)
+		return nil, ErrSessionNotFound
+	}
+
+	return row, nil
+}
+
+// get session by sessionID
+//
+// It returns ErrSessionNotFound and a nil row when the session does not exist or is expired,
+// even if the gc has not deleted it yet. The returned row is pooled, the caller must Release it
+func (db *Dao) getSessionBySessionID(sessionID []byte) (*DBRow, error) {
+	return db.getSessionBySessionIDContext(context.Background(), sessionID)
+}
+
+// get session by sessionID bound to ctx
+//
+// With sliding expiration the last activity is refreshed in the same round trip,
+// otherwise the row is served from the cache when it is enabled
+func (db *Dao) getSessionBySessionIDContext(ctx context.Context, sessionID []byte) (*DBRow, error) {
+	if db.slidingExpiration {
+		return db.getAndTouchContext(ctx, sessionID, db.clock.Now())
+	}
+
+	if row := db.cachedRow(sessionID); row != nil {
+		return row, nil
+	}
+
+	ctx, cancel := db.withOpTimeout(ctx, opGet)
+	defer cancel()
+
+	row, err := foundDBRow(db.fetchDBRow(ctx, opGet, db.sqlGetSessionBySessionID, func() (*sql.Row, error) {
+		return db.readRowContext(ctx, db.sqlGetSessionBySessionID, gotils.B2S(sessionID), db.now())
+	}))
+	if err == nil {
+		db.cacheRow(sessionID, row)
+	}
+
+	return row, err
+}
+
+// get session by sessionID and update its last activity atomically
+//
+// It returns ErrSessionNotFound and a nil row when the session does not exist or is expired
+func (db *Dao) getAndTouch(sessionID []byte, lastActive time.Time) (*DBRow, error) {
+	return db.getAndTouchContext(context.Background(), sessionID, lastActive)
+}
+
+// get session by sessionID and update its last activity atomically bound to ctx
+//
+// The returned row holds the refreshed last activity. The dialects without UPDATE RETURNING
+// update the session and then read it in a transaction
+func (db *Dao) getAndTouchContext(ctx context.Context, sessionID []byte, lastActive time.Time) (*DBRow, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opGet)
+	defer cancel()
+	defer db.invalidate(sessionID)
+
+	if !isPostgresCompatible(db.dialect) {
+		return db.getAndTouchTxContext(ctx, sessionID, lastActive)
+	}
+
+	return foundDBRow(db.fetchDBRow(ctx, opGet, db.sqlGetAndTouch, func() (*sql.Row, error) {
+		return db.queryRowContext(ctx, db.sqlGetAndTouch, db.unixTime(lastActive), gotils.B2S(sessionID))
+	}))
+}
+
+// update the last activity of the alive session and read it in a transaction bound to ctx
+func (db *Dao) getAndTouchTxContext(ctx context.Context, sessionID []byte, lastActive time.Time) (*DBRow, error) {
+	var row *DBRow
+
+	err := db.WithTx(ctx, func(tx *Dao) error {
+		n, err := tx.execContext(ctx, opGet, tx.sqlTouchAlive, tx.unixTime(lastActive), gotils.B2S(sessionID))
+		if err != nil || n == 0 {
+			return err
+		}
+
+		row, err = foundDBRow(tx.fetchDBRow(ctx, opGet, tx.sqlGetStoredBySessionID, func() (*sql.Row, error) {
+			return tx.queryRowContext(ctx, tx.sqlGetStoredBySessionID, gotils.B2S(sessionID))
+		}))
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
+		return nil, ErrSessionNotFound
+	}
+
+	return row, nil
+}
+
+// get the alive sessions of the ids in a single round trip, keyed by session id,
+// the missing and expired ones being left out
+//
+// It binds the ids as a postgres array, the other dialects get the sessions one at a time.
+// The returned rows are not pooled and belong to the caller
+func (db *Dao) getBySessionIDs(ids [][]byte) (map[string]*DBRow, error) {
+	return db.getBySessionIDsContext(context.Background(), ids)
+}
+
+// get the alive sessions of the ids in a single round trip bound to ctx
+func (db *Dao) getBySessionIDsContext(ctx context.Context, ids [][]byte) (map[string]*DBRow, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opGet)
+	defer cancel()
+
+	result := make(map[string]*DBRow, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	if !isPostgresCompatible(db.dialect) {
+		for _, id := range ids {
+			row, err := db.getSessionBySessionIDContext(ctx, id)
+			if err == ErrSessionNotFound {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+
+			owned := new(DBRow)
+			*owned = *row
+			releaseDBRow(row)
+
+			result[owned.sessionID] = owned
+		}
+
+		return result, nil
+	}
+
+	rows, err := db.fetchDBRows(ctx, opGet, db.sqlGetBySessionIDs, func() (*sql.Rows, error) {
+		return db.readContext(ctx, db.sqlGetBySessionIDs, sessionIDsArray(ids), db.now())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		result[row.sessionID] = row
+	}
+
+	return result, nil
+}
+
+// list the not expired sessions, most recently active first
+//
+// The returned rows are not pooled and belong to the caller
+func (db *Dao) listSessions(offset, limit int) ([]*DBRow, error) {
+	return db.listSessionsContext(context.Background(), offset, limit)
+}
+
+// list the not expired sessions, most recently active first, bound to ctx
+func (db *Dao) listSessionsContext(ctx context.Context, offset, limit int) ([]*DBRow, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opList)
+	defer cancel()
+
+	now := db.now()
+
+	return db.fetchDBRows(ctx, opList, db.sqlListSessions, func() (*sql.Rows, error) {
+		return db.readContext(ctx, db.sqlListSessions, limit, offset, now)
+	})
+}
+
+// list the sessions last active before cutoff, the longest idle first, expired ones included
+//
+// Unlike the expiration, it also finds the idle sessions which never expire
+func (db *Dao) listIdleBefore(cutoff time.Time, limit int) ([]*DBRow, error) {
+	return db.listIdleBeforeContext(context.Background(), cutoff, limit)
+}
+
+// list the sessions last active before cutoff, the longest idle first, bound to ctx
+func (db *Dao) listIdleBeforeContext(ctx context.Context, cutoff time.Time, limit int) ([]*DBRow, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opList)
+	defer cancel()
+
+	return db.fetchDBRows(ctx, opList, db.sqlListIdleBefore, func() (*sql.Rows, error) {
+		return db.readContext(ctx, db.sqlListIdleBefore, db.unixTime(cutoff), limit)
+	})
+}
+
+// list the sessions expiring within the window from now, the soonest first,
+// to renew them before they lapse
+//
+// The expiry is the last activity plus the expiration, the absolute deadlines
+// of the expires at mode are not considered
+func (db *Dao) listExpiringWithin(window time.Duration, limit int) ([]*DBRow, error) {
+	return db.listExpiringWithinContext(context.Background(), window, limit)
+}
+
+// list the sessions expiring within the window from now, the soonest first, bound to ctx
+func (db *Dao) listExpiringWithinContext(ctx context.Context, window time.Duration, limit int) ([]*DBRow, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opList)
+	defer cancel()
+
+	now := db.clock.Now()
+
+	return db.fetchDBRows(ctx, opList, db.sqlListExpiringWithin, func() (*sql.Rows, error) {
+		return db.readContext(ctx, db.sqlListExpiringWithin, db.unixTime(now), db.unixTime(now.Add(window)), limit)
+	})
+}
+
+// iterate call fn with every session of the table, expired ones included, stopping at its first error
+//
+// The rows are streamed into a single row, which fn must not retain
+func (db *Dao) iterate(fn func(*DBRow) error) error {
+	return db.iterateContext(context.Background(), fn)
+}
+
+// iterate call fn with every session of the table bound to ctx, stopping at its first error
+func (db *Dao) iterateContext(ctx context.Context, fn func(*DBRow) error) error {
+	ctx, cancel := db.withOpTimeout(ctx, opIterate)
+	defer cancel()
+
+	var rows *sql.Rows
+
+	err := db.run(ctx, opIterate, db.sqlIterate, func() error {
+		var err error
+		rows, err = db.readContext(ctx, db.sqlIterate)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	data := acquireDBRow()
+	defer releaseDBRow(data)
+
+	for rows.Next() {
+		data.Reset()
+		if err = db.scanDBRow(rows, data); err != nil {
+			return err
+		}
+
+		if err = fn(data); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// count sessions, 0 on error
+func (db *Dao) countSessions() int {
+	return db.countSessionsContext(context.Background())
+}
+
+// count sessions bound to ctx, 0 on error
+func (db *Dao) countSessionsContext(ctx context.Context) int {
+	total, _ := db.countSessionsErrContext(ctx)
+	return total
+}
+
+// count sessions, reporting the query errors
+func (db *Dao) countSessionsErr() (int, error) {
+	return db.countSessionsErrContext(context.Background())
+}
+
+// count sessions bound to ctx, reporting the query errors
+func (db *Dao) countSessionsErrContext(ctx context.Context) (int, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opCount)
+	defer cancel()
+
+	var total int
+
+	err := db.run(ctx, opCount, db.sqlCountSessions, func() error {
+		row, err := db.readRowContext(ctx, db.sqlCountSessions)
+		if err != nil {
+			return err
+		}
+
+		return row.Scan(&total)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// estimate the count of the sessions of the whole table from the planner statistics,
+// in constant time instead of the sequential scan of countSessions
+//
+// The estimate is as fresh as the last vacuum or analyze of the table, keep countSessions
+// when precision matters. It falls back to the exact count for the dialects other than
+// postgres and for a table never analyzed yet, which reports -1 since postgres 14 and 0 before,
+// so an estimate of 0 is counted too, cheaply as the table is then likely small
+func (db *Dao) approxCountSessions() (int64, error) {
+	return db.approxCountSessionsContext(context.Background())
+}
+
+// estimate the count of the sessions of the whole table bound to ctx
+func (db *Dao) approxCountSessionsContext(ctx context.Context) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opCount)
+	defer cancel()
+
+	if !isPostgresCompatible(db.dialect) {
+		total, err := db.countSessionsErrContext(ctx)
+		return int64(total), err
+	}
+
+	estimate := int64(-1)
+
+	err := db.run(ctx, opCount, db.sqlApproxCountSessions, func() error {
+		row, err := db.readRowContext(ctx, db.sqlApproxCountSessions, db.quotedTableName)
+		if err != nil {
+			return err
+		}
+
+		return row.Scan(&estimate)
+	})
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	if estimate <= 0 { // never analyzed, or empty
+		total, err := db.countSessionsErrContext(ctx)
+		return int64(total), err
+	}
+
+	return estimate, nil
+}
+
+// sum the stored size in bytes of the contents of the sessions, the tenant ones in the multi-tenant mode
+//
+// The size is the encoded one, after the compression and the encryption, and the expired
+// sessions not deleted by the gc yet are counted. Unlike countSessions it fails instead of
+// returning 0, so a quota is not bypassed by a failed query
+func (db *Dao) totalContentsBytes() (int64, error) {
+	return db.totalContentsBytesContext(context.Background())
+}
+
+// sum the stored size in bytes of the contents of the sessions bound to ctx
+func (db *Dao) totalContentsBytesContext(ctx context.Context) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opCount)
+	defer cancel()
+
+	var total int64
+
+	err := db.run(ctx, opCount, db.sqlTotalContentsBytes, func() error {
+		row, err := db.readRowContext(ctx, db.sqlTotalContentsBytes)
+		if err != nil {
+			return err
+		}
+
+		return row.Scan(&total)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// sum the stored size in bytes of the contents of the sessions of every tenant, keyed by tenant id
+//
+// It requires the multi-tenant mode and reads the whole table, whatever the tenant of the Dao,
+// the tenants without session are left out
+func (db *Dao) totalContentsBytesByTenant() (map[string]int64, error) {
+	return db.totalContentsBytesByTenantContext(context.Background())
+}
+
+// sum the stored size in bytes of the contents of the sessions of every tenant bound to ctx
+func (db *Dao) totalContentsBytesByTenantContext(ctx context.Context) (map[string]int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opCount)
+	defer cancel()
+
+	if !db.multiTenant {
+		return nil, errMultiTenantDisabled
+	}
+
+	totals := make(map[string]int64)
+
+	err := db.run(ctx, opCount, db.sqlTotalContentsBytesByTenant, func() error {
+		rows, err := db.readContext(ctx, db.sqlTotalContentsBytesByTenant)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var tenantID string
+			var total int64
+			if err = rows.Scan(&tenantID, &total); err != nil {
+				return err
+			}
+			totals[tenantID] = total
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return totals, nil
+}
+
+// count the not expired sessions, even if the expired ones are not deleted by the gc yet
+func (db *Dao) countActiveSessions() (int, error) {
+	return db.countActiveSessionsContext(context.Background())
+}
+
+// count the not expired sessions bound to ctx
+func (db *Dao) countActiveSessionsContext(ctx context.Context) (int, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opCount)
+	defer cancel()
+
+	var total int
+	now := db.now()
+
+	err := db.run(ctx, opCount, db.sqlCountActiveSessions, func() error {
+		row, err := db.readRowContext(ctx, db.sqlCountActiveSessions, now)
+		if err != nil {
+			return err
+		}
+
+		return row.Scan(&total)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// count the expired sessions the gc would delete now, without deleting them
+//
+// It previews a cleanup with the same condition as deleteExpiredSessions
+func (db *Dao) countExpiredSessions() (int64, error) {
+	return db.countExpiredSessionsContext(context.Background())
+}
+
+// count the expired sessions the gc would delete now bound to ctx
+func (db *Dao) countExpiredSessionsContext(ctx context.Context) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opCount)
+	defer cancel()
+
+	var total int64
+	now := db.now()
+
+	err := db.run(ctx, opCount, db.sqlCountExpiredSessions, func() error {
+		row, err := db.readRowContext(ctx, db.sqlCountExpiredSessions, now)
+		if err != nil {
+			return err
+		}
+
+		return row.Scan(&total)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// count the alive sessions grouped by their expiration, into the ascending buckets
+// of the expirations up to each bucket and above the previous one
+//
+// The never expiring sessions are counted under SessionNeverExpires and the ones above
+// the last bucket under expirationBucketBeyond. Every bucket is in the result, even empty,
+// so ranging over the sorted buckets displays a stable histogram
+func (db *Dao) countByExpirationBucket(buckets []time.Duration) (map[time.Duration]int64, error) {
+	return db.countByExpirationBucketContext(context.Background(), buckets)
+}
+
+// count the alive sessions grouped by their expiration bound to ctx
+func (db *Dao) countByExpirationBucketContext(ctx context.Context, buckets []time.Duration) (map[time.Duration]int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opCount)
+	defer cancel()
+
+	sorted := make([]time.Duration, 0, len(buckets))
+	for _, bucket := range buckets {
+		if bucket > SessionNeverExpires {
+			sorted = append(sorted, bucket)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	counts := map[time.Duration]int64{SessionNeverExpires: 0, expirationBucketBeyond: 0}
+
+	var whens strings.Builder
+	for i, bucket := range sorted {
+		counts[bucket] = 0
+		fmt.Fprintf(&whens, "WHEN %s<=%d THEN %d ", db.columns.Expiration, db.units(bucket), i+1)
+	}
+	query := strings.Replace(db.sqlCountByExpirationBucket, "{buckets}", whens.String(), 1)
+	args := db.bindArgs(db.sqlCountByExpirationBucket, []interface{}{db.now()})
+
+	err := db.run(ctx, opCount, query, func() error {
+		rows, err := db.readUnpreparedContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var index int
+			var total int64
+			if err = rows.Scan(&index, &total); err != nil {
+				return err
+			}
+
+			switch {
+			case index == 0:
+				counts[SessionNeverExpires] = total
+			case index < 0:
+				counts[expirationBucketBeyond] = total
+			default:
+				counts[sorted[index-1]] = total
+			}
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// find the sessions whose JSON contents have the key set to value
+//
+// It requires the JSON contents mode, the returned rows belong to the caller
+func (db *Dao) findByJSONField(key, value string) ([]*DBRow, error) {
+	return db.findByJSONFieldContext(context.Background(), key, value)
+}
+
+// find the sessions whose JSON contents have the key set to value bound to ctx
+func (db *Dao) findByJSONFieldContext(ctx context.Context, key, value string) ([]*DBRow, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opFind)
+	defer cancel()
+
+	if !db.jsonContents {
+		return nil, errJSONContentsDisabled
+	}
+
+	return db.fetchDBRows(ctx, opFind, db.sqlFindByJSONField, func() (*sql.Rows, error) {
+		return db.readContext(ctx, db.sqlFindByJSONField, key, value)
+	})
+}
+
+// consume a use of the one-time session, decrementing the uses counter of its JSON contents
+// and deleting it when no use is left, returning the remaining uses
+//
+// The decrement and the delete run in a single transaction holding the row lock, so two
+// concurrent consumers never both get the last use. It requires the JSON contents mode and
+// fails with ErrSessionNotFound when the session is missing, expired or has no use left
+func (db *Dao) consumeUse(sessionID []byte) (int, error) {
+	return db.consumeUseContext(context.Background(), sessionID)
+}
+
+// consume a use of the one-time session bound to ctx, returning the remaining uses
+func (db *Dao) consumeUseContext(ctx context.Context, sessionID []byte) (int, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opConsume)
+	defer cancel()
+
+	if !db.jsonContents {
+		return 0, errJSONContentsDisabled
+	}
+
+	defer db.invalidate(sessionID)
+
+	var remaining int
+
+	// a failed statement aborts the transaction, so the retries run it again as a whole
+	err := db.run(ctx, opConsume, db.sqlConsumeUse, func() error {
+		return db.WithTx(ctx, func(tx *Dao) error {
+			row, err := tx.queryRowContext(ctx, tx.sqlConsumeUse, gotils.B2S(sessionID), tx.now())
+			if err != nil {
+				return err
+			}
+			if err = row.Scan(&remaining); err != nil || remaining > 0 {
+				return err
+			}
+
+			args := tx.bindArgs(tx.sqlDeleteBySessionID, []interface{}{gotils.B2S(sessionID)})
+			_, err = tx.execOnce(ctx, tx.stmts, tx.sqlDeleteBySessionID, args...)
+
+			return err
+		})
+	})
+	if err == sql.ErrNoRows {
+		return 0, ErrSessionNotFound
+	} else if err != nil {
+		return 0, err
+	}
+
+	return remaining, nil
+}
+
+// update session by sessionID
+//
+// The expiration is relative to lastActive, SessionNeverExpires or a negative one keeps
+// the session forever and a sub-second one is rounded up to the time unit.
+// It returns the rows affected by the update, 1 when the session exists and 0 otherwise
+func (db *Dao) updateBySessionID(sessionID, contents []byte, lastActive time.Time, expiration time.Duration) (int64, error) {
+	return db.updateBySessionIDContext(context.Background(), sessionID, contents, lastActive, expiration)
+}
+
+// update session by sessionID bound to ctx
+func (db *Dao) updateBySessionIDContext(ctx context.Context, sessionID, contents []byte, lastActive time.Time, expiration time.Duration) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opUpdate)
+	defer cancel()
+	defer db.invalidate(sessionID)
+
+	contents, err := db.encodeContents(contents)
+	if err != nil {
+		return 0, err
+	}
+
+	return db.execContext(ctx, opUpdate, db.sqlUpdateBySessionID, db.contentsArg(contents), db.unixTime(lastActive), db.units(expiration), gotils.B2S(sessionID))
+}
+
+// update session by sessionID, failing with ErrSessionNotFound when it does not exist,
+// like after a concurrent delete
+func (db *Dao) updateOrFail(sessionID, contents []byte, lastActive time.Time, expiration time.Duration) error {
+	return db.updateOrFailContext(context.Background(), sessionID, contents, lastActive, expiration)
+}
+
+// update session by sessionID bound to ctx, failing with ErrSessionNotFound when it does not exist
+func (db *Dao) updateOrFailContext(ctx context.Context, sessionID, contents []byte, lastActive time.Time, expiration time.Duration) error {
+	n, err := db.updateBySessionIDContext(ctx, sessionID, contents, lastActive, expiration)
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// update session by sessionID only when its last activity is still expectedLastActive,
+// failing with ErrConcurrentModification otherwise
+//
+// It is an optimistic lock for the read-modify-write of a session without a transaction:
+// a concurrent update moves the last activity, so the later write fails instead of
+// clobbering the earlier one. The times are compared in the time unit of the Dao,
+// two updates within the same unit are not told apart. A missing session fails the same way
+func (db *Dao) updateIfLastActive(sessionID, contents []byte, expectedLastActive, lastActive time.Time, expiration time.Duration) error {
+	return db.updateIfLastActiveContext(context.Background(), sessionID, contents, expectedLastActive, lastActive, expiration)
+}
+
+// update session by sessionID bound to ctx only when its last activity is still expectedLastActive
+func (db *Dao) updateIfLastActiveContext(ctx context.Context, sessionID, contents []byte, expectedLastActive, lastActive time.Time, expiration time.Duration) error {
+	ctx, cancel := db.withOpTimeout(ctx, opUpdate)
+	defer cancel()
+	defer db.invalidate(sessionID)
+
+	contents, err := db.encodeContents(contents)
+	if err != nil {
+		return err
+	}
+
+	n, err := db.execContext(ctx, opUpdate, db.sqlUpdateIfLastActive, db.contentsArg(contents), db.unixTime(lastActive), db.units(expiration), gotils.B2S(sessionID), db.unixTime(expectedLastActive))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrConcurrentModification
+	}
+
+	return nil
+}
+
+// update only the expiration of the session, leaving its contents and last activity untouched
+func (db *Dao) updateExpiration(sessionID []byte, expiration time.Duration) (int64, error) {
+	return db.updateExpirationContext(context.Background(), sessionID, expiration)
+}
+
+// update only the expiration of the session bound to ctx
+func (db *Dao) updateExpirationContext(ctx context.Context, sessionID []byte, expiration time.Duration) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opUpdate)
+	defer cancel()
+	defer db.invalidate(sessionID)
+
+	return db.execContext(ctx, opUpdate, db.sqlUpdateExpiration, db.units(expiration), gotils.B2S(sessionID))
+}
+
+// extend the expiration of all the alive sessions by the positive duration,
+// like after a maintenance window, returning the number of extended sessions
+//
+// The never expiring and the already expired sessions are left untouched,
+// as are the absolute deadlines of the expires at mode
+func (db *Dao) extendAll(by time.Duration) (int64, error) {
+	return db.extendAllContext(context.Background(), by)
+}
+
+// extend the expiration of all the alive sessions by the positive duration bound to ctx
+func (db *Dao) extendAllContext(ctx context.Context, by time.Duration) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opUpdate)
+	defer cancel()
+	defer db.invalidateAll()
+
+	return db.execContext(ctx, opUpdate, db.sqlExtendAll, db.units(by), db.now())
+}
+
+// update only the contents of the session, leaving its last activity and expiration untouched
+//
+// A background job may change the session data without extending its lifetime
+func (db *Dao) updateContents(sessionID, contents []byte) (int64, error) {
+	return db.updateContentsContext(context.Background(), sessionID, contents)
+}
+
+// update only the contents of the session bound to ctx
+func (db *Dao) updateContentsContext(ctx context.Context, sessionID, contents []byte) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opUpdate)
+	defer cancel()
+	defer db.invalidate(sessionID)
+
+	contents, err := db.encodeContents(contents)
+	if err != nil {
+		return 0, err
+	}
+
+	return db.execContext(ctx, opUpdate, db.sqlUpdateContents, db.contentsArg(contents), gotils.B2S(sessionID))
+}
+
+// touch update only the last activity of the session, leaving its contents untouched,
+// failing with ErrSessionNotFound when it does not exist
+func (db *Dao) touch(sessionID []byte, lastActive time.Time) (int64, error) {
+	return db.touchContext(context.Background(), sessionID, lastActive)
+}
+
+// touch update only the last activity of the session bound to ctx,
+// failing with ErrSessionNotFound when it does not exist
+func (db *Dao) touchContext(ctx context.Context, sessionID []byte, lastActive time.Time) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opTouch)
+	defer cancel()
+	defer db.invalidate(sessionID)
+
+	n, err := db.execContext(ctx, opTouch, db.sqlTouch, db.unixTime(lastActive), gotils.B2S(sessionID))
+	if err == nil && n == 0 {
+		return 0, ErrSessionNotFound
+	}
+
+	return n, err
+}
+
+// delete session by sessionID
+func (db *Dao) deleteBySessionID(sessionID []byte) (int64, error) {
+	return db.deleteBySessionIDContext(context.Background(), sessionID)
+}
+
+// delete session by sessionID bound to ctx
+func (db *Dao) deleteBySessionIDContext(ctx context.Context, sessionID []byte) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opDelete)
+	defer cancel()
+	defer db.invalidate(sessionID)
+
+	return db.execNotifyContext(ctx, sessionID, func(db *Dao) (int64, error) {
+		return db.execContext(ctx, opDelete, db.sqlDeleteBySessionID, gotils.B2S(sessionID))
+	})
+}
+
+// delete session by sessionID and return its row as it was before the delete,
+// failing with ErrSessionNotFound when nothing was deleted
+//
+// The row is returned even if the session was expired, in a single DELETE RETURNING so it can
+// not change between the read and the delete. The dialects without RETURNING read it first
+// in the transaction of the delete
+func (db *Dao) deleteAndReturn(sessionID []byte) (*DBRow, error) {
+	return db.deleteAndReturnContext(context.Background(), sessionID)
+}
+
+// delete session by sessionID and return its prior row bound to ctx
+func (db *Dao) deleteAndReturnContext(ctx context.Context, sessionID []byte) (*DBRow, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opDelete)
+	defer cancel()
+	defer db.invalidate(sessionID)
+
+	var row *DBRow
+
+	_, err := db.execNotifyContext(ctx, sessionID, func(db *Dao) (int64, error) {
+		var err error
+
+		if isPostgresCompatible(db.dialect) {
+			row, err = foundDBRow(db.fetchDBRow(ctx, opDelete, db.sqlDeleteAndReturn, func() (*sql.Row, error) {
+				return db.queryRowContext(ctx, db.sqlDeleteAndReturn, gotils.B2S(sessionID))
+			}))
+		} else {
+			err = db.WithTx(ctx, func(tx *Dao) error {
+				row, err = foundDBRow(tx.fetchDBRow(ctx, opDelete, tx.sqlGetStoredBySessionID, func() (*sql.Row, error) {
+					return tx.queryRowContext(ctx, tx.sqlGetStoredBySessionID, gotils.B2S(sessionID))
+				}))
+				if err != nil {
+					return err
+				}
+
+				_, err = tx.execContext(ctx, opDelete, tx.sqlDeleteBySessionID, gotils.B2S(sessionID))
+
+				return err
+			})
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		return 1, nil
+	})
+	if err != nil {
+		if row != nil {
+			releaseDBRow(row)
+		}
+
+		return nil, err
+	}
+
+	return row, nil
+}
+
+// delete the sessions of the ids in a single statement, returning the total deleted rows
+//
+// It binds the ids as a postgres array, the other dialects get an IN list of one parameter per id
+func (db *Dao) deleteBySessionIDs(ids [][]byte) (int64, error) {
+	return db.deleteBySessionIDsContext(context.Background(), ids)
+}
+
+// delete the sessions of the ids in a single statement bound to ctx
+func (db *Dao) deleteBySessionIDsContext(ctx context.Context, ids [][]byte) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opDelete)
+	defer cancel()
+	defer db.invalidate(ids...)
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	if !isPostgresCompatible(db.dialect) {
+		query, args := db.sessionIDsInQuery(db.sqlDeleteBySessionIDsIn, ids)
+		return db.execUnpreparedContext(ctx, opDelete, query, args...)
+	}
+
+	return db.execNotifyIDsContext(ctx, ids, func(db *Dao) (int64, error) {
+		return db.execContext(ctx, opDelete, db.sqlDeleteBySessionIDs, sessionIDsArray(ids))
+	})
+}
+
+// sessionIDsInQuery return the query with its {ids} list expanded to one bind parameter
+// per id, and its arguments, for the dialects without the postgres arrays
+//
+// The list parameters are numbered after the {tenant} one of the query,
+// which a positional dialect binds after them, in their order of appearance
+func (db *Dao) sessionIDsInQuery(query string, ids [][]byte) (string, []interface{}) {
+	tenant := db.tenantArgs(query, nil)
+	args := make([]interface{}, 0, len(ids)+len(tenant))
+	if !isPositional(db.dialect) {
+		args = append(args, tenant...)
+	}
+
+	params := make([]string, len(ids))
+	for i, id := range ids {
+		params[i] = db.dialect.Placeholder(len(tenant) + i + 1)
+		args = append(args, string(id))
+	}
+
+	if isPositional(db.dialect) {
+		args = append(args, tenant...)
+	}
+
+	return strings.Replace(query, "{ids}", strings.Join(params, ","), 1), args
+}
+
+// sessionIDsArray return the ids as a postgres text array parameter
+//
+// It is bound as the text literal of the array, so it does not rely on the array support of the driver
+func sessionIDsArray(ids [][]byte) driver.Valuer {
+	sessionIDs := make([]string, len(ids))
+	for i, id := range ids {
+		sessionIDs[i] = string(id)
+	}
+
+	return pq.StringArray(sessionIDs)
+}
+
+// delete session by sessionID, reporting whether it existed
+func (db *Dao) deleteBySessionIDExists(sessionID []byte) (bool, error) {
+	return db.deleteBySessionIDExistsContext(context.Background(), sessionID)
+}
+
+// delete session by sessionID bound to ctx, reporting whether it existed
+func (db *Dao) deleteBySessionIDExistsContext(ctx context.Context, sessionID []byte) (bool, error) {
+	n, err := db.deleteBySessionIDContext(ctx, sessionID)
+
+	return n > 0, err
+}
+
+// delete the sessions whose contents have the key set to value, returning the number of deleted rows
+//
+// In the JSON contents mode the key is matched exactly, otherwise it degrades to the sessions
+// whose contents contain the value, which can not work with the encryption or the compression
+func (db *Dao) deleteByContentsField(key, value string) (int64, error) {
+	return db.deleteByContentsFieldContext(context.Background(), key, value)
+}
+
+// delete the sessions whose contents have the key set to value bound to ctx
+func (db *Dao) deleteByContentsFieldContext(ctx context.Context, key, value string) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opDelete)
+	defer cancel()
+	defer db.invalidateAll()
+
+	if !db.jsonContents {
+		return db.deleteByContentsLikeContext(ctx, "%"+escapeLike(value)+"%")
+	}
+
+	return db.execNotifyAllContext(ctx, func(db *Dao) (int64, error) {
+		return db.execContext(ctx, opDelete, db.sqlDeleteByJSONField, key, value)
+	})
+}
+
+// delete the sessions whose raw contents match the LIKE pattern, escaped with a backslash,
+// returning the number of deleted rows
+//
+// It can not work with the encryption or the compression
+func (db *Dao) deleteByContentsLike(pattern string) (int64, error) {
+	return db.deleteByContentsLikeContext(context.Background(), pattern)
+}
+
+// delete the sessions whose raw contents match the LIKE pattern bound to ctx
+func (db *Dao) deleteByContentsLikeContext(ctx context.Context, pattern string) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opDelete)
+	defer cancel()
+	defer db.invalidateAll()
+
+	if db.aead != nil || db.compressionThreshold > 0 {
+		return 0, errContentsNotSearchable
+	}
+
+	return db.execNotifyAllContext(ctx, func(db *Dao) (int64, error) {
+		return db.execContext(ctx, opDelete, db.sqlDeleteByContentsLike, pattern)
+	})
+}
+
+// escapeLike escape the wildcards of a LIKE pattern with a backslash
+func escapeLike(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(s)
+}
+
+// delete all the sessions of the table, resetting it with TRUNCATE when possible
+//
+// It falls back to DELETE when the dialect or the privileges do not allow TRUNCATE,
+// and returns the number of deleted rows then, 0 after a TRUNCATE.
+// The multi-tenant mode always deletes the sessions of the tenant only
+func (db *Dao) deleteAll() (int64, error) {
+	return db.deleteAllContext(context.Background())
+}
+
+// delete all the sessions of the table bound to ctx, resetting it with TRUNCATE when possible
+func (db *Dao) deleteAllContext(ctx context.Context) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opDelete)
+	defer cancel()
+	defer db.invalidateAll()
+
+	if isPostgresCompatible(db.dialect) && !db.multiTenant {
+		_, err := db.execContext(ctx, opDelete, db.sqlTruncate)
+		if err == nil {
+			return 0, db.notifyAllContext(ctx)
+		}
+		if !isInsufficientPrivilege(err) {
+			return 0, err
+		}
+	}
+
+	return db.deleteAllRowsContext(ctx)
+}
+
+// delete all the sessions of the table with DELETE, returning the number of deleted rows
+func (db *Dao) deleteAllRows() (int64, error) {
+	return db.deleteAllRowsContext(context.Background())
+}
+
+// delete all the sessions of the table with DELETE bound to ctx
+func (db *Dao) deleteAllRowsContext(ctx context.Context) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opDelete)
+	defer cancel()
+	defer db.invalidateAll()
+
+	return db.execNotifyAllContext(ctx, func(db *Dao) (int64, error) {
+		return db.execContext(ctx, opDelete, db.sqlDeleteAll)
+	})
+}
+
+// delete session by expiration
+func (db *Dao) deleteExpiredSessions() (int64, error) {
+	return db.deleteExpiredSessionsContext(context.Background())
+}
+
+// delete session by expiration bound to ctx
+//
+// The deleted sessions are passed to the OnExpire callback when it is set
+func (db *Dao) deleteExpiredSessionsContext(ctx context.Context) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opGC)
+	defer cancel()
+
+	return db.execNotifyAllContext(ctx, func(db *Dao) (int64, error) {
+		if db.onExpire != nil {
+			return db.deleteExpiredReturningContext(ctx)
+		}
+
+		return db.execContext(ctx, opGC, db.sqlDeleteExpiredSessions, db.now())
+	})
+}
+
+// delete session by expiration bound to ctx and call OnExpire with every deleted session
+//
+// The dialects without DELETE RETURNING read the expired sessions before deleting them
+// in a transaction
+func (db *Dao) deleteExpiredReturningContext(ctx context.Context) (int64, error) {
+	now := db.now()
+
+	var rows []*DBRow
+	var err error
+
+	if isPostgresCompatible(db.dialect) {
+		rows, err = db.fetchDBRows(ctx, opGC, db.sqlDeleteExpiredReturning, func() (*sql.Rows, error) {
+			return db.queryContext(ctx, db.sqlDeleteExpiredReturning, now)
+		})
+	} else {
+		err = db.WithTx(ctx, func(tx *Dao) error {
+			rows, err = tx.fetchDBRows(ctx, opGC, tx.sqlListExpiredSessions, func() (*sql.Rows, error) {
+				return tx.queryContext(ctx, tx.sqlListExpiredSessions, now)
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.execContext(ctx, opGC, tx.sqlDeleteExpiredSessions, now)
+
+			return err
+		})
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, row := range rows {
+		db.onExpire(row)
+	}
+
+	return int64(len(rows)), nil
+}
+
+// evict the least recently active sessions above max stored ones, returning the evicted ones
+//
+// It caps the table of the sessions rarely expiring, on top of the gc of the expired ones.
+// Every stored session counts, the expired ones not deleted yet included, and the ones
+// of the same last activity are evicted in reverse order of id. The multi-tenant mode
+// caps the sessions of the tenant only
+func (db *Dao) enforceMaxSessions(max int) (int64, error) {
+	return db.enforceMaxSessionsContext(context.Background(), max)
+}
+
+// evict the least recently active sessions above max stored ones bound to ctx
+func (db *Dao) enforceMaxSessionsContext(ctx context.Context, max int) (int64, error) {
+	if max < 0 {
+		return 0, errNegativeMaxSessions
+	}
+
+	ctx, cancel := db.withOpTimeout(ctx, opGC)
+	defer cancel()
+	defer db.invalidateAll()
+
+	return db.execNotifyAllContext(ctx, func(db *Dao) (int64, error) {
+		return db.execContext(ctx, opGC, db.sqlEnforceMaxSessions, max)
+	})
+}
+
+// delete session by expiration in chunks of at most limit rows
+func (db *Dao) deleteExpiredSessionsBatch(limit int) (int64, error) {
+	return db.deleteExpiredSessionsBatchContext(context.Background(), limit)
+}
+
+// delete session by expiration in chunks of at most limit rows bound to ctx
+//
+// It keeps deleting until a chunk affects less than limit rows and returns the total.
+// The limit must be positive
+func (db *Dao) deleteExpiredSessionsBatchContext(ctx context.Context, limit int) (int64, error) {
+	if limit <= 0 {
+		return 0, errInvalidBatchSize
+	}
+
+	ctx, cancel := db.withOpTimeout(ctx, opGC)
+	defer cancel()
+
+	now := db.now()
+
+	var total int64
+	for {
+		n, err := db.execNotifyAllContext(ctx, func(db *Dao) (int64, error) {
+			return db.execContext(ctx, opGC, db.sqlDeleteExpiredSessionsBatch, now, limit)
+		})
+		total += n
+		if err != nil {
+			return total, err
+		}
+
+		if n < int64(limit) {
+			return total, nil
+		}
+	}
+}
+
+// set the absolute deadline of the session, the zero time removes it
+//
+// The session expires at the deadline, whatever its activity, or earlier by its relative expiration.
+// It requires the expires at mode
+func (db *Dao) expireAt(sessionID []byte, expiresAt time.Time) (int64, error) {
+	return db.expireAtContext(context.Background(), sessionID, expiresAt)
+}
+
+// set the absolute deadline of the session bound to ctx
+func (db *Dao) expireAtContext(ctx context.Context, sessionID []byte, expiresAt time.Time) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opExpireAt)
+	defer cancel()
+	defer db.invalidate(sessionID)
+
+	if !db.expiresAt {
+		return 0, errExpiresAtDisabled
+	}
+
+	return db.execContext(ctx, opExpireAt, db.sqlExpireAt, db.unixTime(expiresAt), gotils.B2S(sessionID))
+}
+
+// insert new session
+//
+// The expiration is relative to lastActive, SessionNeverExpires or a negative one keeps
+// the session forever and a sub-second one is rounded up to the time unit.
+// It returns the rows affected by the insert, 1 on success, and never a last insert id,
+// which the session table has no use for and postgres does not support.
+// An existing session id fails with ErrSessionIDConflict
+func (db *Dao) insert(sessionID, contents []byte, lastActive time.Time, expiration time.Duration) (int64, error) {
+	return db.insertContext(context.Background(), sessionID, contents, lastActive, expiration)
+}
+
+// insert new session bound to ctx
+func (db *Dao) insertContext(ctx context.Context, sessionID, contents []byte, lastActive time.Time, expiration time.Duration) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opInsert)
+	defer cancel()
+
+	contents, err := db.encodeContents(contents)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := db.execContext(ctx, opInsert, db.sqlInsert, gotils.B2S(sessionID), db.contentsArg(contents), db.unixTime(lastActive), db.units(expiration))
+	if isUniqueViolation(err) {
+		return 0, ErrSessionIDConflict
+	}
+
+	return n, err
+}
+
+// insert the new session of the row as is, its last activity and its creation time included,
+// like a session imported from another store or a deterministic one of a test
+//
+// The contents of the row are the plain ones, encoded like insert does, and a zero creation time
+// is the last activity. It returns the rows affected by the insert like insert
+func (db *Dao) insertFull(row *DBRow) (int64, error) {
+	return db.insertFullContext(context.Background(), row)
+}
+
+// insert the new session of the row as is bound to ctx
+func (db *Dao) insertFullContext(ctx context.Context, row *DBRow) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opInsert)
+	defer cancel()
+
+	contents, err := db.encodeContents(gotils.S2B(row.contents))
+	if err != nil {
+		return 0, err
+	}
+
+	args := []interface{}{row.sessionID, db.contentsArg(contents), db.unixTime(row.lastActive), db.units(row.expiration)}
+	if db.createdAt {
+		createdAt := row.createdAt
+		if createdAt.IsZero() {
+			createdAt = row.lastActive
+		}
+		args = append(args, db.unixTime(createdAt))
+	}
+
+	n, err := db.execContext(ctx, opInsert, db.sqlInsertFull, args...)
+	if isUniqueViolation(err) {
+		return 0, ErrSessionIDConflict
+	}
+
+	return n, err
+}
+
+// insert the new sessions with multi-row inserts of up to insertBatchSize rows,
+// all of them in a single transaction
+//
+// The deadline and the creation time of the rows are written as well, a zero
+// creation time being the last activity. It returns the amount of inserted sessions
+func (db *Dao) insertBatch(rows []*DBRow) (int64, error) {
+	return db.insertBatchContext(context.Background(), rows)
+}
+
+// insert the new sessions with multi-row inserts in a single transaction, bound to ctx
+func (db *Dao) insertBatchContext(ctx context.Context, rows []*DBRow) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opInsert)
+	defer cancel()
+
+	var total int64
+
+	err := db.WithTx(ctx, func(tx *Dao) error {
+		for start := 0; start < len(rows); start += insertBatchSize {
+			end := start + insertBatchSize
+			if end > len(rows) {
+				end = len(rows)
+			}
+
+			args := make([]interface{}, 0, (end-start)*tx.insertBatchParams+1)
+			for _, row := range rows[start:end] {
+				contents, err := tx.encodeContents(gotils.S2B(row.contents))
+				if err != nil {
+					return err
+				}
+
+				args = append(args, row.sessionID, tx.contentsArg(contents), tx.unixTime(row.lastActive), tx.units(row.expiration))
+				if tx.expiresAt {
+					args = append(args, tx.unixTime(row.expiresAt))
+				}
+				if tx.createdAt {
+					createdAt := row.createdAt
+					if createdAt.IsZero() {
+						createdAt = row.lastActive
+					}
+					args = append(args, tx.unixTime(createdAt))
+				}
+			}
+
+			if tx.multiTenant {
+				args = append(args, tx.tenantID)
+			}
+
+			query, args := tx.insertBatchQuery(end-start, args)
+			n, err := tx.execContext(ctx, opInsert, query, args...)
+			if err != nil {
+				return err
+			}
+			total += n
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// insertBatchQuery return the multi-row insert of n sessions and its arguments,
+// shifting the bind parameters of every next row
+func (db *Dao) insertBatchQuery(n int, args []interface{}) (string, []interface{}) {
+	var b strings.Builder
+	b.WriteString(db.sqlInsertBatch)
+
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(shiftPlaceholders(db.insertBatchValues, i*db.insertBatchParams))
+	}
+
+	// the rows of the multi-tenant mode share the last parameter
+	query := strings.Replace(b.String(), "{tenant}", "$"+strconv.Itoa(n*db.insertBatchParams+1), -1)
+	if !isPositional(db.dialect) {
+		return rebind(db.dialect, query), args
+	}
+
+	query, order := rebindOrder(db.dialect, query)
+	if !isSequential(order) {
+		args = reorderArgs(order, args)
+	}
+
+	return query, args
+}
+
+// shiftPlaceholders add offset to the $n bind parameters of values
+func shiftPlaceholders(values string, offset int) string {
+	var b strings.Builder
+
+	for i := 0; i < len(values); i++ {
+		if values[i] != '$' {
+			b.WriteByte(values[i])
+			continue
+		}
+
+		j := i + 1
+		for j < len(values) && values[j] >= '0' && values[j] <= '9' {
+			j++
+		}
+
+		n, _ := strconv.Atoi(values[i+1 : j])
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(n + offset))
+		i = j - 1
+	}
+
+	return b.String()
+}
+
+// get the session or insert it when it does not exist, in a race free way
+//
+// It reports whether the session has been created
+func (db *Dao) getOrCreate(sessionID, contents []byte, lastActive time.Time, expiration time.Duration) (*DBRow, bool, error) {
+	return db.getOrCreateContext(context.Background(), sessionID, contents, lastActive, expiration)
+}
+
+// get the session or insert it when it does not exist, in a race free way, bound to ctx
+//
+// An expired session is replaced as a new one, otherwise the insert does nothing
+// on conflict and then the existing row is read from the primary
+func (db *Dao) getOrCreateContext(ctx context.Context, sessionID, contents []byte, lastActive time.Time, expiration time.Duration) (*DBRow, bool, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opGetOrCreate)
+	defer cancel()
+
+	contents, err := db.encodeContents(contents)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if db.partitioned {
+		return db.getOrCreatePartitionedContext(ctx, sessionID, contents, lastActive, expiration)
+	}
+	if !isPostgresCompatible(db.dialect) {
+		return db.getOrCreateTxContext(ctx, sessionID, contents, lastActive, expiration)
+	}
+
+	row, err := db.fetchDBRow(ctx, opGetOrCreate, db.sqlInsertIfNotExists, func() (*sql.Row, error) {
+		return db.queryRowContext(ctx, db.sqlInsertIfNotExists, gotils.B2S(sessionID), db.contentsArg(contents), db.unixTime(lastActive), db.units(expiration))
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	if row.sessionID != "" {
+		return row, true, nil
+	}
+	releaseDBRow(row)
+
+	row, err = db.getSessionBySessionIDContext(WithPrimary(ctx), sessionID)
+
+	return row, false, err
+}
+
+// get the alive session or create it with the encoded contents in a transaction bound to ctx,
+// replacing an expired one, for the dialects without INSERT RETURNING
+//
+// An insert losing the race against the one of another transaction reads the session it created
+func (db *Dao) getOrCreateTxContext(ctx context.Context, sessionID, contents []byte, lastActive time.Time, expiration time.Duration) (*DBRow, bool, error) {
+	var row *DBRow
+	var created bool
+
+	err := db.WithTx(ctx, func(tx *Dao) error {
+		var err error
+		row, err = tx.getSessionBySessionIDContext(ctx, sessionID)
+		if err != ErrSessionNotFound {
+			return err
+		}
+
+		if _, err = tx.execContext(ctx, opGetOrCreate, tx.sqlDeleteBySessionID, gotils.B2S(sessionID)); err != nil {
+			return err
+		}
+		if _, err = tx.execContext(ctx, opGetOrCreate, tx.sqlInsert, gotils.B2S(sessionID), tx.contentsArg(contents), tx.unixTime(lastActive), tx.units(expiration)); err != nil {
+			return err
+		}
+		created = true
+
+		row, err = foundDBRow(tx.fetchDBRow(ctx, opGetOrCreate, tx.sqlGetStoredBySessionID, func() (*sql.Row, error) {
+			return tx.queryRowContext(ctx, tx.sqlGetStoredBySessionID, gotils.B2S(sessionID))
+		}))
+
+		return err
+	})
+	if isUniqueViolation(err) {
+		row, err = db.getSessionBySessionIDContext(WithPrimary(ctx), sessionID)
+
+		return row, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return row, created, nil
+}
+
+// save insert or update the session in one atomic statement,
+// with the expiration semantics of insert
+func (db *Dao) save(sessionID, contents []byte, lastActive time.Time, expiration time.Duration) (int64, error) {
+	return db.saveContext(context.Background(), sessionID, contents, lastActive, expiration)
+}
+
+// save insert or update the session in one atomic statement bound to ctx
+func (db *Dao) saveContext(ctx context.Context, sessionID, contents []byte, lastActive time.Time, expiration time.Duration) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opSave)
+	defer cancel()
+	defer db.invalidate(sessionID)
+
+	contents, err := db.encodeContents(contents)
+	if err != nil {
+		return 0, err
+	}
+
+	if db.partitioned {
+		return db.savePartitionedContext(ctx, sessionID, contents, lastActive, expiration)
+	}
+
+	return db.execContext(ctx, opSave, db.sqlSave, gotils.B2S(sessionID), db.contentsArg(contents), db.unixTime(lastActive), db.units(expiration))
+}
+
+// regenerate session id
+func (db *Dao) regenerate(oldID, newID []byte, lastActive time.Time, expiration time.Duration) (int64, error) {
+	return db.regenerateContext(context.Background(), oldID, newID, lastActive, expiration)
+}
+
+// regenerate session id bound to ctx
+//
+// It returns the number of regenerated rows, 0 when the old id does not exist,
+// and ErrSessionIDConflict when the new id already exists
+func (db *Dao) regenerateContext(ctx context.Context, oldID, newID []byte, lastActive time.Time, expiration time.Duration) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opRegenerate)
+	defer cancel()
+	defer db.invalidate(oldID, newID)
+
+	n, err := db.execNotifyContext(ctx, oldID, func(db *Dao) (int64, error) {
+		if db.copyOnRegenerate {
+			return db.regenerateByCopyContext(ctx, oldID, newID, lastActive, expiration)
+		}
+
+		return db.execContext(ctx, opRegenerate, db.sqlRegenerate, gotils.B2S(newID), db.unixTime(lastActive), db.units(expiration), gotils.B2S(oldID))
+	})
+	if isUniqueViolation(err) {
+		return 0, ErrSessionIDConflict
+	}
+
+	return n, err
+}
+
+// regenerate session id bound to ctx inserting a copy of the row with the new id
+// and deleting the old one in a transaction, for the databases that do not
+// update the primary key in place
+func (db *Dao) regenerateByCopyContext(ctx context.Context, oldID, newID []byte, lastActive time.Time, expiration time.Duration) (int64, error) {
+	defer db.invalidate(oldID, newID)
+
+	return db.moveSessionContext(ctx, oldID, db.sqlRegenerateCopy, gotils.B2S(newID), db.unixTime(lastActive), db.units(expiration), gotils.B2S(oldID))
+}
+
+// regenerate session id rewriting its contents in the same statement,
+// so the new id never holds the stale contents, like on a privilege escalation
+func (db *Dao) regenerateWithContents(oldID, newID, contents []byte, lastActive time.Time, expiration time.Duration) (int64, error) {
+	return db.regenerateWithContentsContext(context.Background(), oldID, newID, contents, lastActive, expiration)
+}
+
+// regenerate session id rewriting its contents in the same statement, bound to ctx
+//
+// It returns the number of regenerated rows, 0 when the old id does not exist,
+// and ErrSessionIDConflict when the new id already exists
+func (db *Dao) regenerateWithContentsContext(ctx context.Context, oldID, newID, contents []byte, lastActive time.Time, expiration time.Duration) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opRegenerate)
+	defer cancel()
+	defer db.invalidate(oldID, newID)
+
+	contents, err := db.encodeContents(contents)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := db.execNotifyContext(ctx, oldID, func(db *Dao) (int64, error) {
+		if db.copyOnRegenerate {
+			return db.moveSessionContext(ctx, oldID, db.sqlRegenerateWithContentsCopy, gotils.B2S(newID), db.contentsArg(contents), db.unixTime(lastActive), db.units(expiration), gotils.B2S(oldID))
+		}
+
+		return db.execContext(ctx, opRegenerate, db.sqlRegenerateWithContents, gotils.B2S(newID), db.contentsArg(contents), db.unixTime(lastActive), db.units(expiration), gotils.B2S(oldID))
+	})
+	if isUniqueViolation(err) {
+		return 0, ErrSessionIDConflict
+	}
+
+	return n, err
+}
+
+// regenerate session id carrying the contents, the last activity and the expiration
+// of the old session forward to the new one
+func (db *Dao) regenerateKeepContents(oldID, newID []byte) (int64, error) {
+	return db.regenerateKeepContentsContext(context.Background(), oldID, newID)
+}
+
+// regenerate session id bound to ctx carrying the contents, the last activity
+// and the expiration of the old session forward to the new one
+//
+// The row is copied to the new id and the old one is deleted in a transaction,
+// so it behaves the same whatever the dialect
+func (db *Dao) regenerateKeepContentsContext(ctx context.Context, oldID, newID []byte) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opRegenerate)
+	defer cancel()
+	defer db.invalidate(oldID, newID)
+
+	return db.execNotifyContext(ctx, oldID, func(db *Dao) (int64, error) {
+		return db.moveSessionContext(ctx, oldID, db.sqlRegenerateKeepContents, gotils.B2S(newID), gotils.B2S(oldID))
+	})
+}
+
+// moveSessionContext run the query copying the old session to a new id
+// and delete the old session in a transaction
+//
+// It returns the number of copied rows, and ErrSessionIDConflict when the new id already exists
+func (db *Dao) moveSessionContext(ctx context.Context, oldID []byte, copyQuery string, args ...interface{}) (int64, error) {
+	var n int64
+
+	err := db.WithTx(ctx, func(tx *Dao) error {
+		var err error
+
+		n, err = tx.execContext(ctx, opRegenerate, copyQuery, args...)
+		if err != nil || n == 0 {
+			return err
+		}
+
+		_, err = tx.execContext(ctx, opDelete, tx.sqlDeleteBySessionID, gotils.B2S(oldID))
+
+		return err
+	})
+	if isUniqueViolation(err) {
+		return 0, ErrSessionIDConflict
+	}
+
+	return n, err
 }
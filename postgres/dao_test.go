@@ -0,0 +1,2798 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// getSQLiteTestDao return a Dao backed by a private in-memory sqlite database
+func getSQLiteTestDao(tb testing.TB) *Dao {
+	return getSQLiteTestDaoWithConfig(tb, DaoConfig{})
+}
+
+// getSQLiteTestDaoWithConfig return a Dao with the given configuration
+// backed by a private in-memory sqlite database
+func getSQLiteTestDaoWithConfig(tb testing.TB, cfg DaoConfig) *Dao {
+	cfg.MaxOpenConns = 1
+	cfg.Dialect = SQLiteDialect
+
+	db, err := NewDaoWithConfig("sqlite3", ":memory:", "session", cfg)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	if err = db.EnsureTable(); err != nil {
+		tb.Fatal(err)
+	}
+
+	return db
+}
+
+// getTestDao return a Dao connected to the database of the SESSION_POSTGRES_DSN
+// environment variable, skipping the test when it is not defined
+func getTestDao(tb testing.TB, cfg DaoConfig) *Dao {
+	dsn := os.Getenv("SESSION_POSTGRES_DSN")
+	if dsn == "" {
+		tb.Skip("SESSION_POSTGRES_DSN is not defined")
+	}
+
+	cfg.VerifyConnection = true
+	cfg.EnsureTable = true
+
+	db, err := NewDaoWithConfig("postgres", dsn, "session_test", cfg)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return db
+}
+
+func TestNewDaoWithConfigValidation(t *testing.T) {
+	cases := []struct {
+		driver, dsn, tableName string
+		err                    error
+	}{
+		{"sqlite3", ":memory:", "", errTableNameEmpty},
+		{"sqlite3", "", "session", errDsnEmpty},
+		{"unknown", ":memory:", "session", errUnknownDriver("unknown")},
+	}
+
+	for _, c := range cases {
+		_, err := NewDaoWithConfig(c.driver, c.dsn, c.tableName, DaoConfig{Dialect: SQLiteDialect})
+		if err == nil || err.Error() != c.err.Error() {
+			t.Errorf("NewDaoWithConfig(%q, %q, %q) error == %v, want %v", c.driver, c.dsn, c.tableName, err, c.err)
+		}
+	}
+}
+
+func TestNewDaoContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := NewDaoContext(ctx, "sqlite3", ":memory:", "session"); err != context.Canceled {
+		t.Errorf("NewDaoContext() error == %v, want %v", err, context.Canceled)
+	}
+
+	db, err := NewDaoContext(context.Background(), "sqlite3", ":memory:", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+}
+
+// countingLogger logger counting the errors
+type countingLogger struct {
+	noopLogger
+	errors int
+}
+
+func (l *countingLogger) Errorf(format string, args ...interface{}) {
+	l.errors++
+}
+
+func TestNewDaoConnectRetry(t *testing.T) {
+	logger := &countingLogger{}
+
+	_, err := NewDaoWithConfig("postgres", "postgres://127.0.0.1:1/session?sslmode=disable", "session", DaoConfig{
+		VerifyConnection:        true,
+		ConnectRetryMaxAttempts: 3,
+		ConnectRetryBaseDelay:   time.Millisecond,
+		Logger:                  logger,
+	})
+	if !isConnectionLost(err) {
+		t.Fatalf("NewDaoWithConfig() error == %v, want a lost connection", err)
+	}
+	if logger.errors != 2 {
+		t.Errorf("retries == %d, want %d", logger.errors, 2)
+	}
+}
+
+func TestBuildQueriesColumnNames(t *testing.T) {
+	db := &Dao{dialect: PostgresDialect}
+	if err := db.setTableName("sessions"); err != nil {
+		t.Fatal(err)
+	}
+	db.columns = ColumnNames{SessionID: "sid", Contents: "data", LastActive: "updated_at", Expiration: "ttl"}.withDefaults()
+	db.buildQueries()
+
+	expected := "SELECT sid,data,updated_at,ttl FROM \"sessions\" WHERE sid=$1 AND (ttl=0 OR updated_at+ttl>$2)"
+	if db.sqlGetSessionBySessionID != expected {
+		t.Errorf("sqlGetSessionBySessionID == %s, want %s", db.sqlGetSessionBySessionID, expected)
+	}
+
+	expected = "DELETE FROM \"sessions\" WHERE updated_at+ttl<=$1 AND ttl<>0"
+	if db.sqlDeleteExpiredSessions != expected {
+		t.Errorf("sqlDeleteExpiredSessions == %s, want %s", db.sqlDeleteExpiredSessions, expected)
+	}
+}
+
+func TestColumnNamesDefaults(t *testing.T) {
+	cn := ColumnNames{Contents: "data"}.withDefaults()
+
+	if cn.SessionID != defaultColumnSessionID {
+		t.Errorf("SessionID == %s, want %s", cn.SessionID, defaultColumnSessionID)
+	}
+	if cn.Contents != "data" {
+		t.Errorf("Contents == %s, want %s", cn.Contents, "data")
+	}
+	if cn.LastActive != defaultColumnLastActive {
+		t.Errorf("LastActive == %s, want %s", cn.LastActive, defaultColumnLastActive)
+	}
+	if cn.Expiration != defaultColumnExpiration {
+		t.Errorf("Expiration == %s, want %s", cn.Expiration, defaultColumnExpiration)
+	}
+}
+
+func TestBuildQueriesMySQLDialect(t *testing.T) {
+	db := &Dao{dialect: MySQLDialect}
+	if err := db.setTableName("auth.sessions"); err != nil {
+		t.Fatal(err)
+	}
+	db.columns = ColumnNames{}.withDefaults()
+	db.buildQueries()
+
+	expected := "UPDATE `auth`.`sessions` SET contents=?,last_active=?,expiration=? WHERE session_id=?"
+	if db.sqlUpdateBySessionID != expected {
+		t.Errorf("sqlUpdateBySessionID == %s, want %s", db.sqlUpdateBySessionID, expected)
+	}
+
+	expected = "CREATE TABLE IF NOT EXISTS `auth`.`sessions` (session_id VARCHAR(64) PRIMARY KEY NOT NULL, contents TEXT NOT NULL, " +
+		"last_active BIGINT NOT NULL DEFAULT 0, expiration BIGINT NOT NULL DEFAULT 0, INDEX `sessions_last_active_idx` (last_active))"
+	if db.sqlCreateTable != expected {
+		t.Errorf("sqlCreateTable == %s, want %s", db.sqlCreateTable, expected)
+	}
+	if db.sqlCreateIndex != "" {
+		t.Errorf("sqlCreateIndex == %s, want the index of the table", db.sqlCreateIndex)
+	}
+
+	expected = "INSERT INTO `auth`.`sessions` (session_id, contents, last_active, expiration) VALUES (?,?,?,?) " +
+		"ON DUPLICATE KEY UPDATE contents=VALUES(contents),last_active=VALUES(last_active),expiration=VALUES(expiration)"
+	if db.sqlSave != expected {
+		t.Errorf("sqlSave == %s, want %s", db.sqlSave, expected)
+	}
+
+	expected = "DELETE FROM `auth`.`sessions` WHERE last_active+expiration<=? AND expiration<>0 LIMIT ?"
+	if db.sqlDeleteExpiredSessionsBatch != expected {
+		t.Errorf("sqlDeleteExpiredSessionsBatch == %s, want %s", db.sqlDeleteExpiredSessionsBatch, expected)
+	}
+
+	// every statement binds its arguments in the order of its placeholders
+	v := reflect.ValueOf(db).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		if v.Field(i).Kind() != reflect.String || !strings.HasPrefix(name, "sql") {
+			continue
+		}
+
+		query := v.Field(i).String()
+		if strings.Contains(query, "%!") {
+			t.Errorf("%s == %s, want the names of the table and the columns", name, query)
+		}
+		if strings.Contains(query, "$") {
+			t.Errorf("%s == %s, want the placeholders of the dialect", name, query)
+		}
+
+		placeholders := strings.Count(query, "?")
+		if order := db.argOrders[query]; order != nil && len(order) != placeholders {
+			t.Errorf("%s binds %d arguments, want %d", name, len(order), placeholders)
+		}
+	}
+
+	now, limit, offset, deadline, tenant := "now", "limit", "offset", "deadline", "tenant"
+	db.multiTenant = true
+	db.expiresAt = true
+	db.tenantID = tenant
+	db.buildQueries()
+
+	cases := []struct {
+		name     string
+		query    string
+		args     []interface{}
+		expected []interface{}
+	}{
+		{"sqlListSessions", db.sqlListSessions, []interface{}{limit, offset, now}, []interface{}{now, now, tenant, limit, offset}},
+		{"sqlListExpiringWithin", db.sqlListExpiringWithin, []interface{}{now, deadline, limit}, []interface{}{deadline, now, now, tenant, limit}},
+		{"sqlGetAndTouch", db.sqlGetAndTouch, []interface{}{now, "id"}, []interface{}{now, "id", now, now, tenant}},
+		{"sqlEnforceMaxSessions", db.sqlEnforceMaxSessions, []interface{}{offset}, []interface{}{tenant, offset}},
+		{"sqlDeleteExpiredSessionsBatch", db.sqlDeleteExpiredSessionsBatch, []interface{}{now, limit}, []interface{}{now, now, limit}},
+	}
+	for _, c := range cases {
+		if args := db.bindArgs(c.query, c.args); !reflect.DeepEqual(args, c.expected) {
+			t.Errorf("bindArgs(%s) == %v, want %v", c.name, args, c.expected)
+		}
+	}
+
+	rowArgs := []interface{}{"first", "a", now, "1h", deadline, "second", "b", now, "2h", deadline, tenant}
+	expectedArgs := []interface{}{"first", "a", now, "1h", tenant, deadline, "second", "b", now, "2h", tenant, deadline}
+	if query, args := db.insertBatchQuery(2, rowArgs); strings.Count(query, "?") != len(args) || !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("insertBatchQuery() == %s, %v, want %v", query, args, expectedArgs)
+	}
+
+	expected = "DELETE FROM `auth`.`sessions` WHERE session_id IN (?,?) AND tenant_id=?"
+	expectedArgs = []interface{}{"a", "b", tenant}
+	if query, args := db.sessionIDsInQuery(db.sqlDeleteBySessionIDsIn, [][]byte{[]byte("a"), []byte("b")}); query != expected || !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("sessionIDsInQuery() == %s, %v, want %s, %v", query, args, expected, expectedArgs)
+	}
+}
+
+func TestNewDaoMySQLUnsupported(t *testing.T) {
+	cases := map[string]DaoConfig{
+		"MultiTenant":   {MultiTenant: true},
+		"CreatedAt":     {CreatedAt: true},
+		"NotifyChannel": {NotifyChannel: "session_invalidation"},
+	}
+
+	for option, cfg := range cases {
+		cfg.Dialect = MySQLDialect
+
+		expected := errMySQLUnsupported(option)
+		if _, err := newDao("session", cfg); err == nil || err.Error() != expected.Error() {
+			t.Errorf("newDao() with %s error == %v, want %v", option, err, expected)
+		}
+	}
+}
+
+func TestParseQualifiedName(t *testing.T) {
+	cases := map[string]string{
+		"sessions":               `"sessions"`,
+		"Sessions":               `"sessions"`,
+		"auth.sessions":          `"auth"."sessions"`,
+		`"my schema"."sessions"`: `"my schema"."sessions"`,
+		`auth."Web""Sessions"`:   `"auth"."Web""Sessions"`,
+	}
+
+	for name, expected := range cases {
+		parts, err := parseQualifiedName(name)
+		if err != nil {
+			t.Errorf("parseQualifiedName(%q) unexpected error: %v", name, err)
+			continue
+		}
+
+		if quoted := quoteQualifiedName(PostgresDialect, parts); quoted != expected {
+			t.Errorf("quoteQualifiedName(%q) == %s, want %s", name, quoted, expected)
+		}
+	}
+
+	invalid := []string{"", ".", "a.", ".a", "a.b.c", `"unterminated`, `""`, "sessions; DROP TABLE users;--", "1sessions", "a b"}
+	for _, name := range invalid {
+		if _, err := parseQualifiedName(name); err == nil {
+			t.Errorf("parseQualifiedName(%q) expected error", name)
+		}
+	}
+}
+
+func TestValidateIdentifier(t *testing.T) {
+	for _, name := range []string{"session_id", "LastActive", "_ttl", "col$1", "c2"} {
+		if err := validateIdentifier(name); err != nil {
+			t.Errorf("validateIdentifier(%q) unexpected error: %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"", "1col", "$col", "a b", "a.b", `"quoted"`, "sid; DROP TABLE users;--", "sid--", "é"} {
+		if err := validateIdentifier(name); err == nil {
+			t.Errorf("validateIdentifier(%q) expected error", name)
+		}
+	}
+
+	cfg := DaoConfig{Columns: ColumnNames{Contents: "contents=''; DROP TABLE users;--"}}
+	if _, err := newDao("session", cfg); err == nil {
+		t.Error("newDao() with an injected column name expected error")
+	}
+}
+
+func TestSQLiteDao(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	sessionID := []byte("sqlite")
+	now := time.Now()
+
+	if _, err := db.insert(sessionID, []byte("v1"), now, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.save(sessionID, []byte("v2"), now, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	row, err := db.getSessionBySessionID(sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(row.Contents()) != "v2" {
+		t.Errorf("Contents() == %s, want %s", row.Contents(), "v2")
+	}
+	if row.Expiration() != time.Hour {
+		t.Errorf("Expiration() == %s, want %s", row.Expiration(), time.Hour)
+	}
+	if string(row.SessionID()) != string(sessionID) || row.LastActive().Unix() != now.Unix() {
+		t.Errorf("SessionID(), LastActive() == %s, %d, want %s, %d", row.SessionID(), row.LastActive().Unix(), sessionID, now.Unix())
+	}
+
+	if total := db.countSessions(); total != 1 {
+		t.Errorf("countSessions() == %d, want %d", total, 1)
+	}
+
+	if _, err = db.updateExpiration(sessionID, 30*24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if row, err = db.getSessionBySessionID(sessionID); err != nil || row.expiration != 30*24*time.Hour || row.contents != "v2" {
+		t.Errorf("updateExpiration() row == %v, %v, want the %s expiration", row, err, 30*24*time.Hour)
+	}
+
+	if _, err = db.updateContents(sessionID, []byte("v3")); err != nil {
+		t.Fatal(err)
+	}
+	if row, err = db.getSessionBySessionID(sessionID); err != nil || row.contents != "v3" || row.expiration != 30*24*time.Hour || row.LastActive().Unix() != now.Unix() {
+		t.Errorf("updateContents() row == %v, %v, want the v3 contents only", row, err)
+	}
+
+	n, err := db.deleteBySessionID(sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("deleteBySessionID() == %d, want %d", n, 1)
+	}
+
+	if exists, err := db.deleteBySessionIDExists(sessionID); err != nil || exists {
+		t.Errorf("deleteBySessionIDExists() == %v, %v, want %v, %v", exists, err, false, nil)
+	}
+
+	if row, err = db.getSessionBySessionID(sessionID); err != ErrSessionNotFound {
+		t.Errorf("getSessionBySessionID() == %v, %v, want %v", row, err, ErrSessionNotFound)
+	}
+
+	if err = db.updateOrFail(sessionID, []byte("v3"), now, time.Hour); err != ErrSessionNotFound {
+		t.Errorf("updateOrFail() == %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func TestSQLiteDaoExpiredSession(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	sessionID := []byte("expired")
+	db.insert(sessionID, []byte("old"), time.Now().Add(-10*time.Second), 5*time.Second)
+
+	if row, err := db.getSessionBySessionID(sessionID); err != ErrSessionNotFound {
+		t.Errorf("getSessionBySessionID() == %v, %v, want %v", row, err, ErrSessionNotFound)
+	}
+}
+
+func TestSQLiteDaoExpiresAt(t *testing.T) {
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{ExpiresAt: true})
+	defer db.Close()
+
+	now := time.Now()
+	db.insert([]byte("deadline"), nil, now, time.Hour)
+	db.insert([]byte("alive"), nil, now, 0)
+
+	if _, err := db.expireAt([]byte("deadline"), now.Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if row, err := db.getSessionBySessionID([]byte("deadline")); err != ErrSessionNotFound {
+		t.Errorf("getSessionBySessionID() == %v, %v, want %v", row, err, ErrSessionNotFound)
+	}
+
+	n, err := db.deleteExpiredSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("deleteExpiredSessions() == %d, want %d", n, 1)
+	}
+}
+
+func TestSQLiteDaoDeleteExpiredSessions(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	now := time.Now()
+
+	db.insert([]byte("expired"), nil, now.Add(-10*time.Second), 5*time.Second)
+	db.insert([]byte("alive"), nil, now, time.Hour)
+	db.insert([]byte("forever"), nil, now.Add(-10*time.Second), 0)
+
+	n, err := db.deleteExpiredSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("deleteExpiredSessions() == %d, want %d", n, 1)
+	}
+
+	if total := db.countSessions(); total != 2 {
+		t.Errorf("countSessions() == %d, want %d", total, 2)
+	}
+}
+
+func TestSQLiteDaoCountActiveSessions(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	now := time.Now()
+
+	db.insert([]byte("expired"), nil, now.Add(-10*time.Second), 5*time.Second)
+	db.insert([]byte("alive"), nil, now, time.Hour)
+	db.insert([]byte("forever"), nil, now.Add(-10*time.Second), 0)
+
+	total, err := db.countActiveSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 {
+		t.Errorf("countActiveSessions() == %d, want %d", total, 2)
+	}
+}
+
+func TestSQLiteDaoApproxCountSessions(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	db.insert([]byte("first"), nil, time.Now(), time.Hour)
+	db.insert([]byte("second"), nil, time.Now(), time.Hour)
+
+	if total, err := db.approxCountSessions(); err != nil || total != 2 {
+		t.Errorf("approxCountSessions() == %d, %v, want %d", total, err, 2)
+	}
+}
+
+func TestSQLiteDaoCountExpiredSessions(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	now := time.Now()
+
+	db.insert([]byte("expired"), nil, now.Add(-10*time.Second), 5*time.Second)
+	db.insert([]byte("alive"), nil, now, time.Hour)
+	db.insert([]byte("forever"), nil, now.Add(-10*time.Second), 0)
+
+	total, err := db.countExpiredSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 {
+		t.Errorf("countExpiredSessions() == %d, want %d", total, 1)
+	}
+
+	if n, _ := db.deleteExpiredSessions(); n != total {
+		t.Errorf("deleteExpiredSessions() == %d, want %d", n, total)
+	}
+}
+
+func TestSQLiteDaoHealthCheck(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	if err := db.HealthCheck(context.Background()); err != nil {
+		t.Errorf("HealthCheck() unexpected error: %v", err)
+	}
+
+	db.Connection.Exec("DROP TABLE session")
+	if err := db.HealthCheck(context.Background()); err == nil {
+		t.Error("HealthCheck() expected error with the table dropped")
+	}
+}
+
+func TestSQLiteDaoStats(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	stats := db.Stats()
+	if stats.MaxOpenConnections != 1 {
+		t.Errorf("MaxOpenConnections == %d, want %d", stats.MaxOpenConnections, 1)
+	}
+	if stats.OpenConnections != 1 {
+		t.Errorf("OpenConnections == %d, want %d", stats.OpenConnections, 1)
+	}
+}
+
+type testMetrics struct {
+	ops []string
+}
+
+func (m *testMetrics) ObserveQuery(op string, duration time.Duration, err error) {
+	if err != nil {
+		op += " error"
+	}
+	m.ops = append(m.ops, op)
+}
+
+func TestSQLiteDaoMetrics(t *testing.T) {
+	metrics := new(testMetrics)
+
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{Metrics: metrics})
+	defer db.Close()
+
+	db.insert([]byte("metrics"), nil, time.Now(), time.Hour)
+	db.getSessionBySessionID([]byte("missing"))
+	db.insert([]byte("metrics"), nil, time.Now(), time.Hour)
+
+	expected := []string{opInsert, opGet, opInsert + " error"}
+	if len(metrics.ops) != len(expected) {
+		t.Fatalf("observed operations == %v, want %v", metrics.ops, expected)
+	}
+	for i := range expected {
+		if metrics.ops[i] != expected[i] {
+			t.Errorf("observed operations == %v, want %v", metrics.ops, expected)
+		}
+	}
+}
+
+type testTracer struct {
+	spans []string
+}
+
+func (tr *testTracer) StartSpan(ctx context.Context, name string, attrs map[string]string) func(err error) {
+	return func(err error) {
+		span := name + " " + attrs["db.sql.table"]
+		if err != nil {
+			span += " error"
+		}
+		tr.spans = append(tr.spans, span)
+	}
+}
+
+func TestSQLiteDaoTracer(t *testing.T) {
+	tracer := new(testTracer)
+
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{Tracer: tracer})
+	defer db.Close()
+
+	db.insert([]byte("traced"), nil, time.Now(), time.Hour)
+	db.getSessionBySessionID([]byte("missing"))
+	db.insert([]byte("traced"), nil, time.Now(), time.Hour)
+
+	expected := []string{"session.insert session", "session.get session", "session.insert session error"}
+	if len(tracer.spans) != len(expected) {
+		t.Fatalf("spans == %v, want %v", tracer.spans, expected)
+	}
+	for i := range expected {
+		if tracer.spans[i] != expected[i] {
+			t.Errorf("spans == %v, want %v", tracer.spans, expected)
+		}
+	}
+}
+
+func TestDaoGetOrCreateExpiredSession(t *testing.T) {
+	db := getTestDao(t, DaoConfig{})
+	defer db.Close()
+
+	sessionID := []byte("expired")
+	db.deleteBySessionID(sessionID)
+	db.insert(sessionID, []byte("old"), time.Now().Add(-10*time.Second), 5*time.Second)
+
+	row, created, err := db.getOrCreate(sessionID, []byte("new"), time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || row.contents != "new" {
+		t.Errorf("getOrCreate() == %s, %v, want %s, %v", row.contents, created, "new", true)
+	}
+
+	_, created, err = db.getOrCreate(sessionID, nil, time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created {
+		t.Errorf("getOrCreate() created == %v, want %v", created, false)
+	}
+}
+
+func TestDaoDeleteBySessionIDs(t *testing.T) {
+	db := getTestDao(t, DaoConfig{})
+	defer db.Close()
+
+	ids := [][]byte{[]byte("bulk1"), []byte("bulk2"), []byte("bulk3")}
+	for _, id := range ids {
+		if _, err := db.save(id, nil, time.Now(), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := db.deleteBySessionIDs(append(ids, []byte("missing")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(ids)) {
+		t.Errorf("deleteBySessionIDs() == %d, want %d", n, len(ids))
+	}
+}
+
+func TestDaoGCAdvisoryLock(t *testing.T) {
+	db := getTestDao(t, DaoConfig{GCAdvisoryLock: true})
+	defer db.Close()
+
+	err := db.WithTx(context.Background(), func(tx *Dao) error {
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", tx.gcLockKey); err != nil {
+			return err
+		}
+
+		if _, locked, err := db.gcCycle(context.Background()); err != nil || locked {
+			t.Errorf("gcCycle() == %v, %v, want the cycle skipped", locked, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, locked, err := db.gcCycle(context.Background()); err != nil || !locked {
+		t.Errorf("gcCycle() == %v, %v, want the cycle run", locked, err)
+	}
+}
+
+func TestGCAdvisoryLockUnsupported(t *testing.T) {
+	for _, dialect := range []Dialect{SQLiteDialect, MySQLDialect, CockroachDialect} {
+		if _, err := newDao("session", DaoConfig{Dialect: dialect, GCAdvisoryLock: true}); err != errGCAdvisoryLockUnsupported {
+			t.Errorf("newDao(%T) error == %v, want %v", dialect, err, errGCAdvisoryLockUnsupported)
+		}
+	}
+}
+
+func TestDaoSubscribeInvalidations(t *testing.T) {
+	db := getTestDao(t, DaoConfig{NotifyChannel: "session_invalidation"})
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	invalidations, err := db.SubscribeInvalidations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessionID := []byte("invalidated")
+	db.save(sessionID, nil, time.Now(), time.Hour)
+	if _, err = db.deleteBySessionID(sessionID); err != nil {
+		t.Fatal(err)
+	}
+
+	if id := <-invalidations; id != string(sessionID) {
+		t.Errorf("invalidated session id == %s, want %s", id, sessionID)
+	}
+
+	ids := [][]byte{[]byte("first"), []byte("second")}
+	for _, id := range ids {
+		db.save(id, nil, time.Now(), time.Hour)
+	}
+	if _, err = db.deleteBySessionIDs(ids); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range ids {
+		if id := <-invalidations; id != string(expected) {
+			t.Errorf("invalidated session id == %s, want %s", id, expected)
+		}
+	}
+
+	db.save(sessionID, nil, time.Now(), time.Hour)
+	if _, err = db.deleteAll(); err != nil {
+		t.Fatal(err)
+	}
+	if id := <-invalidations; id != "" {
+		t.Errorf("invalidated session id == %s, want all of them", id)
+	}
+}
+
+func TestDaoBulkImport(t *testing.T) {
+	db := getTestDao(t, DaoConfig{})
+	defer db.Close()
+
+	rows := make(chan *DBRow)
+	go func() {
+		defer close(rows)
+
+		for i := 0; i < 3; i++ {
+			rows <- &DBRow{sessionID: fmt.Sprintf("imported-%d", i), contents: "data", lastActive: time.Now(), expiration: time.Hour}
+		}
+	}()
+
+	n, err := db.bulkImport(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.deleteBySessionIDs([][]byte{[]byte("imported-0"), []byte("imported-1"), []byte("imported-2")})
+
+	if n != 3 {
+		t.Errorf("bulkImport() == %d, want %d", n, 3)
+	}
+
+	row, err := db.getSessionBySessionID([]byte("imported-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer row.Release()
+
+	if row.expiration != time.Hour {
+		t.Errorf("expiration == %s, want %s", row.expiration, time.Hour)
+	}
+}
+
+func TestDaoBulkImportExpiresAt(t *testing.T) {
+	base := getTestDao(t, DaoConfig{ExpiresAt: true})
+	defer base.Close()
+
+	db, err := base.Table("session_test_import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.EnsureTable(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DROP TABLE " + db.quotedTableName)
+
+	expiresAt := time.Now().Add(time.Minute).Truncate(time.Second)
+
+	rows := make(chan *DBRow, 1)
+	rows <- &DBRow{sessionID: "imported", contents: "data", lastActive: time.Now(), expiration: time.Hour, expiresAt: expiresAt}
+	close(rows)
+
+	if _, err = db.bulkImport(rows); err != nil {
+		t.Fatal(err)
+	}
+
+	row, err := db.getSessionBySessionID([]byte("imported"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer row.Release()
+
+	if !row.ExpiresAt().Equal(expiresAt) {
+		t.Errorf("ExpiresAt() == %v, want %v", row.ExpiresAt(), expiresAt)
+	}
+}
+
+func TestDaoRegenerateWithContentsConflict(t *testing.T) {
+	db := getTestDao(t, DaoConfig{})
+	defer db.Close()
+
+	oldID, newID := []byte("escalated-old"), []byte("escalated-new")
+	db.save(oldID, nil, time.Now(), time.Hour)
+	db.save(newID, nil, time.Now(), time.Hour)
+	defer db.deleteBySessionIDs([][]byte{oldID, newID})
+
+	if _, err := db.regenerateWithContents(oldID, newID, []byte("user"), time.Now(), time.Hour); err != ErrSessionIDConflict {
+		t.Errorf("regenerateWithContents() error == %v, want %v", err, ErrSessionIDConflict)
+	}
+}
+
+func TestDaoApproxCountSessions(t *testing.T) {
+	db := getTestDao(t, DaoConfig{})
+	defer db.Close()
+
+	db.save([]byte("estimated"), nil, time.Now(), time.Hour)
+	defer db.deleteBySessionID([]byte("estimated"))
+	db.Connection.Exec("ANALYZE " + db.quotedTableName)
+
+	if total, err := db.approxCountSessions(); err != nil || total < 1 {
+		t.Errorf("approxCountSessions() == %d, %v, want at least %d", total, err, 1)
+	}
+}
+
+func TestDaoApproxCountSessionsNeverAnalyzed(t *testing.T) {
+	base := getTestDao(t, DaoConfig{})
+	defer base.Close()
+
+	db, err := base.Table("session_test_unanalyzed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.EnsureTable(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DROP TABLE " + db.quotedTableName)
+
+	db.save([]byte("a"), nil, time.Now(), time.Hour)
+	db.save([]byte("b"), nil, time.Now(), time.Hour)
+
+	if total, err := db.approxCountSessions(); err != nil || total != 2 {
+		t.Errorf("approxCountSessions() of a table never analyzed == %d, %v, want %d", total, err, 2)
+	}
+}
+
+func TestDaoBinaryContents(t *testing.T) {
+	db := getTestDao(t, DaoConfig{BinaryContents: true})
+	defer db.Close()
+
+	contents := []byte{0xff, 0x00, 0xfe}
+	db.save([]byte("binary"), contents, time.Now(), time.Hour)
+	defer db.deleteBySessionID([]byte("binary"))
+
+	row, err := db.getSessionBySessionID([]byte("binary"))
+	if err != nil || row.contents != string(contents) {
+		t.Errorf("getSessionBySessionID() == %v, %v, want %q", row, err, contents)
+	}
+}
+
+func TestDaoOnExpire(t *testing.T) {
+	var expired []string
+
+	db := getTestDao(t, DaoConfig{
+		OnExpire: func(row *DBRow) {
+			expired = append(expired, string(row.SessionID()))
+		},
+	})
+	defer db.Close()
+
+	db.save([]byte("returned"), nil, time.Now().Add(-time.Hour), time.Minute)
+
+	if _, err := db.deleteExpiredSessions(); err != nil {
+		t.Errorf("deleteExpiredSessions() unexpected error: %v", err)
+	}
+
+	found := false
+	for _, sessionID := range expired {
+		found = found || sessionID == "returned"
+	}
+	if !found {
+		t.Errorf("OnExpire() called with %v, want %s among them", expired, "returned")
+	}
+}
+
+func TestDaoStatementTimeout(t *testing.T) {
+	db := getTestDao(t, DaoConfig{StatementTimeout: 50 * time.Millisecond})
+	defer db.Close()
+
+	if _, err := db.Exec("SELECT pg_sleep(1)"); err == nil {
+		t.Error("Exec() unexpected success, want the statement timeout error")
+	}
+}
+
+func TestDaoGetBySessionIDs(t *testing.T) {
+	db := getTestDao(t, DaoConfig{})
+	defer db.Close()
+
+	db.save([]byte("multi"), []byte("one"), time.Now(), time.Hour)
+	defer db.deleteBySessionID([]byte("multi"))
+
+	rows, err := db.getBySessionIDs([][]byte{[]byte("multi"), []byte("missing")})
+	if err != nil || len(rows) != 1 || rows["multi"].contents != "one" {
+		t.Errorf("getBySessionIDs() == %v, %v, want the multi session", rows, err)
+	}
+}
+
+func TestDaoInsertRowsAffected(t *testing.T) {
+	db := getTestDao(t, DaoConfig{})
+	defer db.Close()
+
+	db.deleteBySessionID([]byte("fresh"))
+	defer db.deleteBySessionID([]byte("fresh"))
+
+	if n, err := db.insert([]byte("fresh"), nil, time.Now(), time.Hour); err != nil || n != 1 {
+		t.Errorf("insert() == %d, %v, want %d", n, err, 1)
+	}
+	if _, err := db.insert([]byte("fresh"), nil, time.Now(), time.Hour); err != ErrSessionIDConflict {
+		t.Errorf("insert() == %v, want %v", err, ErrSessionIDConflict)
+	}
+}
+
+func TestDaoConsumeUse(t *testing.T) {
+	conn := getTestDao(t, DaoConfig{})
+	conn.Close()
+
+	db, err := NewDaoWithConfig(conn.Driver, conn.Dsn, "session_json_test", DaoConfig{JSONContents: true, EnsureTable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer db.Exec("DROP TABLE " + db.quotedTableName)
+
+	if _, err = db.save([]byte("link"), []byte(`{"uses":2}`), time.Now(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []int{1, 0} {
+		if remaining, err := db.consumeUse([]byte("link")); err != nil || remaining != want {
+			t.Errorf("consumeUse() == %d, %v, want %d", remaining, err, want)
+		}
+	}
+
+	if _, err := db.consumeUse([]byte("link")); err != ErrSessionNotFound {
+		t.Errorf("consumeUse() == %v, want %v", err, ErrSessionNotFound)
+	}
+	if _, err := db.getSessionBySessionID([]byte("link")); err != ErrSessionNotFound {
+		t.Errorf("getSessionBySessionID() == %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func TestDaoDeleteAndReturn(t *testing.T) {
+	db := getTestDao(t, DaoConfig{})
+	defer db.Close()
+
+	db.save([]byte("logout"), []byte("final"), time.Now(), time.Hour)
+
+	row, err := db.deleteAndReturn([]byte("logout"))
+	if err != nil || row.contents != "final" {
+		t.Fatalf("deleteAndReturn() == %v, %v, want contents %q", row, err, "final")
+	}
+	row.Release()
+
+	if _, err = db.getSessionBySessionID([]byte("logout")); err != ErrSessionNotFound {
+		t.Errorf("getSessionBySessionID() == %v, want %v", err, ErrSessionNotFound)
+	}
+	if _, err = db.deleteAndReturn([]byte("logout")); err != ErrSessionNotFound {
+		t.Errorf("deleteAndReturn() == %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func TestDaoEnforceMaxSessions(t *testing.T) {
+	db := getTestDao(t, DaoConfig{})
+	defer db.Close()
+
+	db.deleteAll()
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		db.save([]byte(fmt.Sprintf("capped%d", i)), nil, now.Add(time.Duration(i)*time.Minute), time.Hour)
+	}
+	defer db.deleteAll()
+
+	if n, err := db.enforceMaxSessions(3); err != nil || n != 2 {
+		t.Errorf("enforceMaxSessions() == %d, %v, want %d", n, err, 2)
+	}
+	if _, err := db.getSessionBySessionID([]byte("capped1")); err != ErrSessionNotFound {
+		t.Errorf("getSessionBySessionID() == %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func TestNewDaoFromConnector(t *testing.T) {
+	dsn := os.Getenv("SESSION_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("SESSION_POSTGRES_DSN is not defined")
+	}
+
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewDaoFromConnector(connector, "session_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err = db.EnsureTable(); err != nil {
+		t.Errorf("EnsureTable() unexpected error: %v", err)
+	}
+}
+
+func TestNewDaoFromConnectorSearchPath(t *testing.T) {
+	conn := getTestDao(t, DaoConfig{})
+	defer conn.Close()
+
+	if _, err := conn.Exec("CREATE SCHEMA IF NOT EXISTS session_search_path_test"); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Exec("DROP SCHEMA session_search_path_test CASCADE")
+
+	connector, err := pq.NewConnector(conn.Dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewDaoFromConnectorWithConfig(connector, "session_test", DaoConfig{
+		SearchPath:  []string{"session_search_path_test"},
+		EnsureTable: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var found bool
+	err = conn.Connection.QueryRow("SELECT to_regclass('session_search_path_test.session_test') IS NOT NULL").Scan(&found)
+	if err != nil || !found {
+		t.Errorf("table in the search path schema == %v, %v, want %v", found, err, true)
+	}
+}
+
+func benchmarkGetSessionBySessionID(b *testing.B, cfg DaoConfig) {
+	db := getTestDao(b, cfg)
+	defer db.Close()
+
+	sessionID := []byte("benchmark")
+	if _, err := db.save(sessionID, []byte("contents"), time.Now(), time.Hour); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := db.getSessionBySessionID(sessionID); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetSessionBySessionID(b *testing.B) {
+	benchmarkGetSessionBySessionID(b, DaoConfig{})
+}
+
+func BenchmarkGetSessionBySessionIDWithoutStatementCache(b *testing.B) {
+	benchmarkGetSessionBySessionID(b, DaoConfig{DisableStatementCache: true})
+}
+
+func TestSQLiteDaoWithTx(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	now := time.Now()
+	db.insert([]byte("anonymous"), nil, now, time.Hour)
+
+	err := db.WithTx(context.Background(), func(tx *Dao) error {
+		if _, err := tx.deleteBySessionID([]byte("anonymous")); err != nil {
+			return err
+		}
+		_, err := tx.insert([]byte("authenticated"), []byte("user"), now, time.Hour)
+
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row, err := db.getSessionBySessionID([]byte("authenticated"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.contents != "user" {
+		t.Errorf("contents == %s, want %s", row.contents, "user")
+	}
+
+	err = db.WithTx(context.Background(), func(tx *Dao) error {
+		tx.deleteBySessionID([]byte("authenticated"))
+		tx.insert([]byte("authenticated"), nil, now, time.Hour)
+		_, err := tx.insert([]byte("authenticated"), nil, now, time.Hour)
+
+		return err
+	})
+	if err == nil {
+		t.Fatal("WithTx() expected error inserting a duplicated session")
+	}
+
+	if total := db.countSessions(); total != 1 {
+		t.Errorf("countSessions() == %d, want %d", total, 1)
+	}
+}
+
+func TestSQLiteDaoInsertBatch(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	now := time.Now()
+	rows := make([]*DBRow, insertBatchSize+10)
+	for i := range rows {
+		rows[i] = &DBRow{sessionID: fmt.Sprintf("session-%d", i), contents: "data", lastActive: now, expiration: time.Hour}
+	}
+
+	n, err := db.insertBatch(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(rows)) {
+		t.Errorf("insertBatch() == %d, want %d", n, len(rows))
+	}
+
+	row, err := db.getSessionBySessionID([]byte(fmt.Sprintf("session-%d", insertBatchSize+5)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer row.Release()
+
+	if row.contents != "data" {
+		t.Errorf("contents == %s, want %s", row.contents, "data")
+	}
+	if row.expiration != time.Hour {
+		t.Errorf("expiration == %s, want %s", row.expiration, time.Hour)
+	}
+
+	if _, err = db.insertBatch(rows[:1]); err == nil {
+		t.Error("insertBatch() expected error inserting a duplicated session")
+	}
+	if total := db.countSessions(); total != len(rows) {
+		t.Errorf("countSessions() == %d, want %d", total, len(rows))
+	}
+}
+
+func TestSQLiteDaoBulkImport(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	rows := make(chan *DBRow)
+	close(rows)
+
+	if _, err := db.bulkImport(rows); err != errCopyUnsupported {
+		t.Errorf("bulkImport() error == %v, want %v", err, errCopyUnsupported)
+	}
+}
+
+func TestSQLiteDaoListIdleBefore(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	now := time.Now()
+	db.insert([]byte("idle"), nil, now.Add(-48*time.Hour), 0)
+	db.insert([]byte("idler"), nil, now.Add(-72*time.Hour), time.Hour)
+	db.insert([]byte("active"), nil, now, 0)
+
+	rows, err := db.listIdleBefore(now.Add(-24*time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("listIdleBefore() == %d rows, want %d", len(rows), 2)
+	}
+	if rows[0].sessionID != "idler" || rows[1].sessionID != "idle" {
+		t.Errorf("listIdleBefore() == %s, %s, want %s, %s", rows[0].sessionID, rows[1].sessionID, "idler", "idle")
+	}
+
+	if rows, _ = db.listIdleBefore(now.Add(-24*time.Hour), 1); len(rows) != 1 {
+		t.Errorf("listIdleBefore() == %d rows, want %d", len(rows), 1)
+	}
+}
+
+func TestSQLiteDaoMultiTenant(t *testing.T) {
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{MultiTenant: true})
+	defer db.Close()
+
+	acme, err := db.ForTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	globex, _ := db.ForTenant("globex")
+
+	sessionID := []byte("shared")
+	now := time.Now()
+	if _, err = acme.insert(sessionID, []byte("acme"), now, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = globex.insert(sessionID, []byte("globex"), now, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = globex.save([]byte("expired"), nil, now.Add(-time.Hour), time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	row, err := acme.getSessionBySessionID(sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.contents != "acme" {
+		t.Errorf("contents == %s, want %s", row.contents, "acme")
+	}
+	row.Release()
+
+	if _, err = db.getSessionBySessionID(sessionID); err != ErrSessionNotFound {
+		t.Errorf("getSessionBySessionID() error == %v, want %v", err, ErrSessionNotFound)
+	}
+
+	if n, _ := acme.deleteBySessionID(sessionID); n != 1 {
+		t.Errorf("deleteBySessionID() == %d, want %d", n, 1)
+	}
+	if total := globex.countSessions(); total != 2 {
+		t.Errorf("countSessions() == %d, want %d", total, 2)
+	}
+
+	if n, _ := db.deleteExpiredSessions(); n != 1 {
+		t.Errorf("deleteExpiredSessions() == %d, want %d", n, 1)
+	}
+	if total := globex.countSessions(); total != 1 {
+		t.Errorf("countSessions() == %d, want %d", total, 1)
+	}
+
+	batch := []*DBRow{{sessionID: "first", lastActive: now}, {sessionID: "second", lastActive: now}}
+	if n, err := acme.insertBatch(batch); err != nil || n != 2 {
+		t.Errorf("insertBatch() == %d, %v, want %d", n, err, 2)
+	}
+	if total := acme.countSessions(); total != 2 {
+		t.Errorf("countSessions() == %d, want %d", total, 2)
+	}
+
+	plain := getSQLiteTestDao(t)
+	defer plain.Close()
+
+	if _, err = plain.ForTenant("acme"); err != errMultiTenantDisabled {
+		t.Errorf("ForTenant() error == %v, want %v", err, errMultiTenantDisabled)
+	}
+}
+
+func TestSQLiteDaoUpdateIfLastActive(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	sessionID := []byte("optimistic")
+	read := time.Now().Add(-time.Minute)
+	db.insert(sessionID, []byte("first"), read, time.Hour)
+
+	if err := db.updateIfLastActive(sessionID, []byte("second"), read, time.Now(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.updateIfLastActive(sessionID, []byte("third"), read, time.Now(), time.Hour); err != ErrConcurrentModification {
+		t.Errorf("updateIfLastActive() error == %v, want %v", err, ErrConcurrentModification)
+	}
+
+	row, err := db.getSessionBySessionID(sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer row.Release()
+
+	if row.contents != "second" {
+		t.Errorf("contents == %s, want %s", row.contents, "second")
+	}
+}
+
+func TestSQLiteDaoListExpiringWithin(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	now := time.Now()
+	db.insert([]byte("soon"), nil, now, 2*time.Minute)
+	db.insert([]byte("sooner"), nil, now, time.Minute)
+	db.insert([]byte("later"), nil, now, time.Hour)
+	db.insert([]byte("expired"), nil, now.Add(-time.Hour), time.Minute)
+	db.insert([]byte("forever"), nil, now, SessionNeverExpires)
+
+	rows, err := db.listExpiringWithin(5*time.Minute, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].sessionID != "sooner" || rows[1].sessionID != "soon" {
+		t.Errorf("listExpiringWithin() == %v, want the sooner and soon sessions", rows)
+	}
+}
+
+func TestSQLiteDaoExtendAll(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	now := time.Now()
+	db.insert([]byte("active"), nil, now, time.Hour)
+	db.insert([]byte("expired"), nil, now.Add(-time.Hour), time.Minute)
+	db.insert([]byte("forever"), nil, now, SessionNeverExpires)
+
+	if n, err := db.extendAll(time.Hour); err != nil || n != 1 {
+		t.Errorf("extendAll() == %d, %v, want %d", n, err, 1)
+	}
+
+	if row, err := db.getSessionBySessionID([]byte("active")); err != nil || row.expiration != 2*time.Hour {
+		t.Errorf("getSessionBySessionID() == %v, %v, want the expiration %s", row, err, 2*time.Hour)
+	}
+	if row, err := db.getSessionBySessionID([]byte("forever")); err != nil || row.expiration != SessionNeverExpires {
+		t.Errorf("getSessionBySessionID() == %v, %v, want the expiration %s", row, err, SessionNeverExpires)
+	}
+}
+
+func TestSQLiteDaoOnExpire(t *testing.T) {
+	var expired []string
+
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{
+		OnExpire: func(row *DBRow) {
+			expired = append(expired, string(row.SessionID())+"="+string(row.Contents()))
+		},
+	})
+	defer db.Close()
+
+	now := time.Now()
+	db.insert([]byte("active"), []byte("kept"), now, time.Hour)
+	db.insert([]byte("expired"), []byte("audited"), now.Add(-time.Hour), time.Minute)
+
+	if n, err := db.deleteExpiredSessions(); err != nil || n != 1 {
+		t.Errorf("deleteExpiredSessions() == %d, %v, want %d", n, err, 1)
+	}
+	if len(expired) != 1 || expired[0] != "expired=audited" {
+		t.Errorf("OnExpire() called with %v, want %v", expired, []string{"expired=audited"})
+	}
+	if total := db.countSessions(); total != 1 {
+		t.Errorf("countSessions() == %d, want %d", total, 1)
+	}
+}
+
+func TestSQLiteDaoCountByExpirationBucket(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	now := time.Now()
+	db.insert([]byte("forever"), nil, now, SessionNeverExpires)
+	db.insert([]byte("minutes"), nil, now, 10*time.Minute)
+	db.insert([]byte("hour"), nil, now, time.Hour)
+	db.insert([]byte("hours"), nil, now, 3*time.Hour)
+	db.insert([]byte("week"), nil, now, 7*24*time.Hour)
+	db.insert([]byte("expired"), nil, now.Add(-time.Hour), time.Minute)
+
+	counts, err := db.countByExpirationBucket([]time.Duration{24 * time.Hour, time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[time.Duration]int64{
+		SessionNeverExpires:    1,
+		time.Hour:              2,
+		24 * time.Hour:         1,
+		expirationBucketBeyond: 1,
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("countByExpirationBucket() == %v, want %v", counts, expected)
+	}
+}
+
+func TestSQLiteDaoCountByExpirationBucketNotPrepared(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	db.insert([]byte("hour"), nil, time.Now(), time.Hour)
+	db.countSessions()
+
+	db.stmts.mu.RLock()
+	prepared := len(db.stmts.stmts)
+	db.stmts.mu.RUnlock()
+
+	for i := 1; i <= 5; i++ {
+		if _, err := db.countByExpirationBucket([]time.Duration{time.Duration(i) * time.Minute}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db.stmts.mu.RLock()
+	defer db.stmts.mu.RUnlock()
+
+	if n := len(db.stmts.stmts); n != prepared {
+		t.Errorf("prepared statements == %d, want %d", n, prepared)
+	}
+}
+
+func TestSQLiteDaoCache(t *testing.T) {
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{CacheSize: 10, CacheTTL: time.Minute})
+	defer db.Close()
+
+	db.insert([]byte("cached"), []byte("first"), time.Now(), time.Hour)
+
+	row, err := db.getSessionBySessionID([]byte("cached"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	row.Release()
+
+	// written behind the back of the Dao, served stale until the ttl
+	db.Connection.Exec("UPDATE " + db.quotedTableName + " SET contents='outside'")
+
+	if row, err = db.getSessionBySessionID([]byte("cached")); err != nil || row.contents != "first" {
+		t.Errorf("getSessionBySessionID() == %v, %v, want the cached %s", row, err, "first")
+	}
+
+	db.updateBySessionID([]byte("cached"), []byte("second"), time.Now(), time.Hour)
+
+	if row, err = db.getSessionBySessionID([]byte("cached")); err != nil || row.contents != "second" {
+		t.Errorf("getSessionBySessionID() == %v, %v, want %s", row, err, "second")
+	}
+
+	db.deleteBySessionID([]byte("cached"))
+
+	if _, err = db.getSessionBySessionID([]byte("cached")); err != ErrSessionNotFound {
+		t.Errorf("getSessionBySessionID() == %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func TestSQLiteDaoCacheExpiresAt(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{CacheSize: 10, CacheTTL: time.Hour, ExpiresAt: true, Clock: clock})
+	defer db.Close()
+
+	db.insert([]byte("cached"), nil, clock.Now(), time.Hour)
+	if _, err := db.expireAt([]byte("cached"), clock.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	row, err := db.getSessionBySessionID([]byte("cached"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	row.Release()
+
+	clock.now = clock.now.Add(2 * time.Minute)
+
+	if _, err = db.getSessionBySessionID([]byte("cached")); err != ErrSessionNotFound {
+		t.Errorf("getSessionBySessionID() past the deadline error == %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func TestSQLiteDaoCopyTo(t *testing.T) {
+	src := getSQLiteTestDao(t)
+	defer src.Close()
+
+	dst := getSQLiteTestDaoWithConfig(t, DaoConfig{TimeUnit: time.Millisecond})
+	defer dst.Close()
+
+	now := time.Now()
+	src.insert([]byte("a"), []byte("first"), now, time.Hour)
+	src.insert([]byte("b"), []byte("second"), now, 90*time.Second)
+	src.insert([]byte("c"), []byte("third"), now, SessionNeverExpires)
+	src.insert([]byte("expired"), nil, now.Add(-time.Hour), time.Minute)
+
+	n, lastID, err := src.copyToAfter(dst, []byte("a"), 1)
+	if err != nil || n != 2 || string(lastID) != "c" {
+		t.Errorf("copyToAfter() == %d, %s, %v, want %d, %s", n, lastID, err, 2, "c")
+	}
+
+	if n, err = src.copyTo(dst, 0); err == nil {
+		t.Errorf("copyTo() == %d, want the duplicated session error", n)
+	}
+
+	row, err := dst.getSessionBySessionID([]byte("b"))
+	if err != nil || row.contents != "second" || row.expiration != 90*time.Second {
+		t.Errorf("getSessionBySessionID() == %v, %v, want %s expiring in %s", row, err, "second", 90*time.Second)
+	}
+	if total := dst.countSessions(); total != 2 {
+		t.Errorf("countSessions() == %d, want %d", total, 2)
+	}
+}
+
+func TestSQLiteDaoCopyToDeadline(t *testing.T) {
+	cfg := DaoConfig{ExpiresAt: true, CreatedAt: true}
+	src := getSQLiteTestDaoWithConfig(t, cfg)
+	defer src.Close()
+
+	dst := getSQLiteTestDaoWithConfig(t, cfg)
+	defer dst.Close()
+
+	now := time.Now()
+	created := now.Add(-time.Hour).Truncate(time.Second)
+	deadline := now.Add(time.Hour).Truncate(time.Second)
+	src.insertFull(&DBRow{sessionID: "deadline", lastActive: now, expiration: 2 * time.Hour, createdAt: created})
+	src.expireAt([]byte("deadline"), deadline)
+
+	if n, err := src.copyTo(dst, 0); err != nil || n != 1 {
+		t.Fatalf("copyTo() == %d, %v, want %d", n, err, 1)
+	}
+
+	row, err := dst.getSessionBySessionID([]byte("deadline"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer row.Release()
+
+	if !row.createdAt.Equal(created) {
+		t.Errorf("createdAt == %s, want %s", row.createdAt, created)
+	}
+	if !row.expiresAt.Equal(deadline) {
+		t.Errorf("expiresAt == %s, want %s", row.expiresAt, deadline)
+	}
+}
+
+// fakeClock clock of the tests advanced by hand
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestSQLiteDaoClock(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{Clock: clock})
+	defer db.Close()
+
+	db.insert([]byte("minute"), nil, clock.Now(), time.Minute)
+	db.insert([]byte("hour"), nil, clock.Now(), time.Hour)
+
+	clock.now = clock.now.Add(2 * time.Minute)
+
+	if n, err := db.deleteExpiredSessions(); err != nil || n != 1 {
+		t.Errorf("deleteExpiredSessions() == %d, %v, want %d", n, err, 1)
+	}
+	if _, err := db.getSessionBySessionID([]byte("minute")); err != ErrSessionNotFound {
+		t.Errorf("getSessionBySessionID() == %v, want %v", err, ErrSessionNotFound)
+	}
+	if row, err := db.getSessionBySessionID([]byte("hour")); err != nil {
+		t.Errorf("getSessionBySessionID() == %v, %v, want the session", row, err)
+	}
+}
+
+func TestSQLiteDaoGetAndTouch(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	now := time.Now().Truncate(time.Second)
+	db.insert([]byte("idle"), []byte("contents"), now.Add(-30*time.Minute), time.Hour)
+	db.insert([]byte("expired"), nil, now.Add(-2*time.Hour), time.Hour)
+
+	row, err := db.getAndTouch([]byte("idle"), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !row.lastActive.Equal(now) || row.contents != "contents" {
+		t.Errorf("getAndTouch() == %s, %s, want %s, %s", row.lastActive, row.contents, now, "contents")
+	}
+	row.Release()
+
+	if row, err = db.getSessionBySessionID([]byte("idle")); err != nil || !row.lastActive.Equal(now) {
+		t.Errorf("stored last activity == %v, %v, want %s", row, err, now)
+	}
+
+	for _, sessionID := range []string{"expired", "missing"} {
+		if _, err = db.getAndTouch([]byte(sessionID), now); err != ErrSessionNotFound {
+			t.Errorf("getAndTouch(%s) == %v, want %v", sessionID, err, ErrSessionNotFound)
+		}
+	}
+}
+
+func TestSQLiteDaoSlidingExpiration(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{Clock: clock, SlidingExpiration: true})
+	defer db.Close()
+
+	db.insert([]byte("sliding"), nil, clock.Now(), time.Hour)
+
+	for i := 0; i < 3; i++ {
+		clock.now = clock.now.Add(40 * time.Minute)
+
+		row, err := db.getSessionBySessionID([]byte("sliding"))
+		if err != nil {
+			t.Fatalf("getSessionBySessionID() after %d reads == %v, want the session kept alive", i, err)
+		}
+		if !row.lastActive.Equal(clock.now) {
+			t.Errorf("lastActive == %s, want %s", row.lastActive, clock.now)
+		}
+		row.Release()
+	}
+
+	clock.now = clock.now.Add(2 * time.Hour)
+	if _, err := db.getSessionBySessionID([]byte("sliding")); err != ErrSessionNotFound {
+		t.Errorf("getSessionBySessionID() == %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func TestSQLiteDaoGetOrCreate(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	now := time.Now()
+	db.insert([]byte("expired"), []byte("stale"), now.Add(-2*time.Hour), time.Hour)
+
+	row, created, err := db.getOrCreate([]byte("new"), []byte("first"), now, time.Hour)
+	if err != nil || !created || row.contents != "first" {
+		t.Fatalf("getOrCreate() == %v, %v, %v, want the created session", row, created, err)
+	}
+	row.Release()
+
+	row, created, err = db.getOrCreate([]byte("new"), []byte("second"), now, time.Hour)
+	if err != nil || created || row.contents != "first" {
+		t.Errorf("getOrCreate() of an existing session == %v, %v, %v, want %s", row, created, err, "first")
+	}
+	row.Release()
+
+	row, created, err = db.getOrCreate([]byte("expired"), []byte("fresh"), now, time.Hour)
+	if err != nil || !created || row.contents != "fresh" {
+		t.Errorf("getOrCreate() of an expired session == %v, %v, %v, want %s", row, created, err, "fresh")
+	}
+	row.Release()
+
+	if total := db.countSessions(); total != 2 {
+		t.Errorf("countSessions() == %d, want %d", total, 2)
+	}
+}
+
+func TestSQLiteDaoGetBySessionIDs(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	now := time.Now()
+	db.insert([]byte("user"), []byte("own"), now, time.Hour)
+	db.insert([]byte("shared"), []byte("board"), now, time.Hour)
+	db.insert([]byte("expired"), nil, now.Add(-time.Hour), time.Minute)
+
+	rows, err := db.getBySessionIDs([][]byte{[]byte("user"), []byte("shared"), []byte("expired"), []byte("missing")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows["user"].contents != "own" || rows["shared"].contents != "board" {
+		t.Errorf("getBySessionIDs() == %v, want the user and shared sessions", rows)
+	}
+}
+
+func TestSQLiteDaoGetBySessionIDsNotPooled(t *testing.T) {
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{CacheSize: 10, CacheTTL: time.Minute})
+	defer db.Close()
+
+	db.insert([]byte("user"), []byte("own"), time.Now(), time.Hour)
+
+	rows, err := db.getBySessionIDs([][]byte{[]byte("user")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// recycle the pooled rows of the reads, the returned ones must not be among them
+	for i := 0; i < 10; i++ {
+		row, err := db.getSessionBySessionID([]byte("user"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row == rows["user"] {
+			t.Fatal("getBySessionIDs() returned a pooled row")
+		}
+		row.Release()
+
+		reused := acquireDBRow()
+		reused.contents = "reused"
+		releaseDBRow(reused)
+	}
+
+	if row := rows["user"]; row == nil || row.sessionID != "user" || row.contents != "own" {
+		t.Errorf("getBySessionIDs() row == %v, want the user session untouched by the pool", row)
+	}
+}
+
+func TestSQLiteDaoInsertRowsAffected(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	if n, err := db.insert([]byte("fresh"), nil, time.Now(), time.Hour); err != nil || n != 1 {
+		t.Errorf("insert() == %d, %v, want %d", n, err, 1)
+	}
+	if n, err := db.insert([]byte("fresh"), nil, time.Now(), time.Hour); err != ErrSessionIDConflict || n != 0 {
+		t.Errorf("insert() == %d, %v, want %d, %v", n, err, 0, ErrSessionIDConflict)
+	}
+}
+
+func TestSQLiteDaoConsumeUseDisabled(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	if _, err := db.consumeUse([]byte("link")); err != errJSONContentsDisabled {
+		t.Errorf("consumeUse() == %v, want %v", err, errJSONContentsDisabled)
+	}
+}
+
+func TestSQLiteDaoBufferReuse(t *testing.T) {
+	for _, cfg := range []DaoConfig{{}, {BinaryContents: true, CacheSize: 16}} {
+		db := getSQLiteTestDaoWithConfig(t, cfg)
+
+		var wg sync.WaitGroup
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+
+			go func(w int) {
+				defer wg.Done()
+
+				sessionID := []byte(fmt.Sprintf("reuse%d", w))
+				buf := make([]byte, 32)
+
+				for i := 0; i < 20; i++ {
+					want := bytes.Repeat([]byte{byte('a' + i)}, len(buf))
+					copy(buf, want)
+
+					if _, err := db.save(sessionID, buf, time.Now(), time.Hour); err != nil {
+						t.Error(err)
+						return
+					}
+					copy(buf, bytes.Repeat([]byte("X"), len(buf)))
+
+					row, err := db.getSessionBySessionID(sessionID)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if row.contents != string(want) {
+						t.Errorf("getSessionBySessionID() contents == %q, want %q", row.contents, want)
+					}
+					releaseDBRow(row)
+				}
+			}(w)
+		}
+		wg.Wait()
+
+		db.Close()
+	}
+}
+
+func TestSQLiteDaoTotalContentsBytes(t *testing.T) {
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{MultiTenant: true})
+	defer db.Close()
+
+	acme, _ := db.ForTenant("acme")
+	globex, _ := db.ForTenant("globex")
+
+	if total, err := acme.totalContentsBytes(); err != nil || total != 0 {
+		t.Errorf("totalContentsBytes() == %d, %v, want %d", total, err, 0)
+	}
+
+	acme.save([]byte("a1"), []byte("héllo"), time.Now(), time.Hour)
+	acme.save([]byte("a2"), []byte("abc"), time.Now(), time.Hour)
+	globex.save([]byte("g1"), []byte("globex"), time.Now(), time.Hour)
+
+	if total, err := acme.totalContentsBytes(); err != nil || total != 9 {
+		t.Errorf("totalContentsBytes() == %d, %v, want %d", total, err, 9)
+	}
+
+	totals, err := db.totalContentsBytesByTenant()
+	if want := map[string]int64{"acme": 9, "globex": 6}; err != nil || !reflect.DeepEqual(totals, want) {
+		t.Errorf("totalContentsBytesByTenant() == %v, %v, want %v", totals, err, want)
+	}
+
+	db.Exec("DROP TABLE " + db.quotedTableName)
+	if _, err := acme.totalContentsBytes(); err == nil {
+		t.Error("totalContentsBytes() of a dropped table unexpected success")
+	}
+}
+
+func TestSQLiteDaoDeleteAndReturn(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	db.save([]byte("logout"), []byte("final"), time.Now().Add(-time.Hour), time.Second)
+
+	row, err := db.deleteAndReturn([]byte("logout"))
+	if err != nil || row.contents != "final" {
+		t.Fatalf("deleteAndReturn() == %v, %v, want contents %q", row, err, "final")
+	}
+	row.Release()
+
+	if _, err = db.deleteAndReturn([]byte("logout")); err != ErrSessionNotFound {
+		t.Errorf("deleteAndReturn() == %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func TestSQLiteDaoInsertFull(t *testing.T) {
+	for _, cfg := range []DaoConfig{{CreatedAt: true}, {CreatedAt: true, MultiTenant: true}, {}} {
+		db := getSQLiteTestDaoWithConfig(t, cfg)
+
+		createdAt := time.Unix(1500000000, 0)
+		lastActive := time.Now().Add(-time.Minute).Truncate(time.Second)
+		row := &DBRow{sessionID: "imported", contents: "history", lastActive: lastActive, expiration: time.Hour, createdAt: createdAt}
+
+		if n, err := db.insertFull(row); err != nil || n != 1 {
+			t.Fatalf("insertFull() == %d, %v, want %d", n, err, 1)
+		}
+		if _, err := db.insertFull(row); err != ErrSessionIDConflict {
+			t.Errorf("insertFull() == %v, want %v", err, ErrSessionIDConflict)
+		}
+
+		got, err := db.getSessionBySessionID([]byte("imported"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.contents != row.contents || !got.lastActive.Equal(lastActive) || got.expiration != time.Hour {
+			t.Errorf("getSessionBySessionID() == %v, want %v", got, row)
+		}
+		if cfg.CreatedAt && !got.createdAt.Equal(createdAt) {
+			t.Errorf("CreatedAt() == %v, want %v", got.createdAt, createdAt)
+		}
+		got.Release()
+
+		db.Close()
+	}
+}
+
+func TestSQLiteDaoOperationTimeouts(t *testing.T) {
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{OperationTimeouts: map[string]time.Duration{opGet: time.Nanosecond, opUpdate: 0}})
+	defer db.Close()
+
+	for op, want := range map[string]time.Duration{opGet: time.Nanosecond, opUpdate: 0, opGC: 0, opCopy: 0, opSave: defaultOpTimeout} {
+		ctx, cancel := db.withOpTimeout(context.Background(), op)
+		if deadline, ok := ctx.Deadline(); ok != (want > 0) || (ok && time.Until(deadline) > want) {
+			t.Errorf("withOpTimeout(%s) deadline == %v, %v, want within %s", op, deadline, ok, want)
+		}
+		cancel()
+	}
+
+	db.save([]byte("slow"), nil, time.Now(), time.Hour)
+	if _, err := db.getSessionBySessionID([]byte("slow")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("getSessionBySessionID() == %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestSQLiteDaoOperationTimeoutsBulkWrites(t *testing.T) {
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{OperationTimeouts: map[string]time.Duration{opDelete: time.Nanosecond, opRegenerate: time.Nanosecond}})
+	defer db.Close()
+
+	db.save([]byte("slow"), nil, time.Now(), time.Hour)
+
+	if _, err := db.deleteAllRows(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("deleteAllRows() == %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := db.regenerateKeepContents([]byte("slow"), []byte("renewed")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("regenerateKeepContents() == %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// reversedContents contents value storing the contents reversed
+type reversedContents struct {
+	contents string
+}
+
+func reverse(s string) string {
+	b := []byte(s)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+
+	return string(b)
+}
+
+func (v *reversedContents) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case string:
+		v.contents = reverse(src)
+	case []byte:
+		v.contents = reverse(string(src))
+	default:
+		return fmt.Errorf("unexpected contents %T", src)
+	}
+
+	return nil
+}
+
+func (v *reversedContents) Value() (driver.Value, error) {
+	return reverse(v.contents), nil
+}
+
+func (v *reversedContents) Contents() string {
+	return v.contents
+}
+
+func (v *reversedContents) SetContents(contents []byte) {
+	v.contents = string(contents)
+}
+
+func TestSQLiteDaoContentsValue(t *testing.T) {
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{ContentsValue: func() ContentsValue { return new(reversedContents) }})
+	defer db.Close()
+
+	db.save([]byte("custom"), []byte("plain"), time.Now(), time.Hour)
+
+	var raw string
+	if err := db.Connection.QueryRow("SELECT contents FROM session").Scan(&raw); err != nil || raw != "nialp" {
+		t.Errorf("stored contents == %q, %v, want %q", raw, err, "nialp")
+	}
+
+	row, err := db.getSessionBySessionID([]byte("custom"))
+	if err != nil || row.contents != "plain" {
+		t.Fatalf("getSessionBySessionID() == %v, %v, want contents %q", row, err, "plain")
+	}
+	row.Release()
+}
+
+func TestSQLiteDaoEnforceMaxSessions(t *testing.T) {
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{MultiTenant: true})
+	defer db.Close()
+
+	acme, _ := db.ForTenant("acme")
+	globex, _ := db.ForTenant("globex")
+
+	now := time.Now()
+	for i, sessionID := range []string{"oldest", "older", "newer", "newest"} {
+		acme.save([]byte(sessionID), nil, now.Add(time.Duration(i)*time.Minute), SessionNeverExpires)
+	}
+	globex.save([]byte("oldest"), nil, now.Add(-time.Hour), SessionNeverExpires)
+
+	if n, err := acme.enforceMaxSessions(2); err != nil || n != 2 {
+		t.Errorf("enforceMaxSessions() == %d, %v, want %d", n, err, 2)
+	}
+	for sessionID, want := range map[string]error{"oldest": ErrSessionNotFound, "older": ErrSessionNotFound, "newer": nil, "newest": nil} {
+		row, err := acme.getSessionBySessionID([]byte(sessionID))
+		if err != want {
+			t.Errorf("getSessionBySessionID(%s) == %v, want %v", sessionID, err, want)
+		}
+		if row != nil {
+			row.Release()
+		}
+	}
+
+	if n, err := acme.enforceMaxSessions(2); err != nil || n != 0 {
+		t.Errorf("enforceMaxSessions() under the cap == %d, %v, want %d", n, err, 0)
+	}
+	if total, err := globex.countSessionsErr(); err != nil || total != 1 {
+		t.Errorf("countSessionsErr() of the other tenant == %d, %v, want %d", total, err, 1)
+	}
+	if _, err := acme.enforceMaxSessions(-1); err != errNegativeMaxSessions {
+		t.Errorf("enforceMaxSessions() == %v, want %v", err, errNegativeMaxSessions)
+	}
+}
+
+func TestSQLiteDaoBinaryContentsMarkers(t *testing.T) {
+	for _, cfg := range []DaoConfig{{BinaryContents: true}, {BinaryContents: true, CompressionThreshold: 64}} {
+		db := getSQLiteTestDaoWithConfig(t, cfg)
+
+		for _, contents := range [][]byte{{0x01, 'g', 'o', 'b'}, {0x02, 0xff, 0x00, 0x10}, {0x01}, {0x02}} {
+			if _, err := db.save([]byte("marked"), contents, time.Now(), time.Hour); err != nil {
+				t.Fatal(err)
+			}
+
+			row, err := db.getSessionBySessionID([]byte("marked"))
+			if err != nil || row.contents != string(contents) {
+				t.Errorf("getSessionBySessionID() with a compression threshold of %d == %v, %v, want %q",
+					cfg.CompressionThreshold, row, err, contents)
+			}
+			if row != nil {
+				row.Release()
+			}
+		}
+
+		db.Close()
+	}
+}
+
+func TestSQLiteDaoDeleteExpiredSessionsBatch(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		db.save([]byte(fmt.Sprintf("expired%d", i)), nil, now.Add(-time.Hour), time.Minute)
+	}
+	db.save([]byte("alive"), nil, now, time.Hour)
+
+	if n, err := db.deleteExpiredSessionsBatch(2); err != nil || n != 5 {
+		t.Errorf("deleteExpiredSessionsBatch() == %d, %v, want %d", n, err, 5)
+	}
+	if total, err := db.countSessionsErr(); err != nil || total != 1 {
+		t.Errorf("countSessionsErr() == %d, %v, want %d", total, err, 1)
+	}
+	if n, err := db.deleteExpiredSessionsBatch(2); err != nil || n != 0 {
+		t.Errorf("deleteExpiredSessionsBatch() without expired sessions == %d, %v, want %d", n, err, 0)
+	}
+
+	for _, limit := range []int{0, -1} {
+		if _, err := db.deleteExpiredSessionsBatch(limit); err != errInvalidBatchSize {
+			t.Errorf("deleteExpiredSessionsBatch(%d) == %v, want %v", limit, err, errInvalidBatchSize)
+		}
+	}
+}
+
+func TestSQLiteDaoPoolOptions(t *testing.T) {
+	db, err := NewDaoWithConfig("sqlite3", ":memory:", "session", DaoConfig{
+		Dialect:         SQLiteDialect,
+		MaxOpenConns:    3,
+		MaxIdleConns:    -1,
+		ConnMaxLifetime: time.Minute,
+		ConnMaxIdleTime: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err = db.Connection.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := db.Stats()
+	if stats.MaxOpenConnections != 3 {
+		t.Errorf("MaxOpenConnections == %d, want %d", stats.MaxOpenConnections, 3)
+	}
+	if stats.Idle != 0 {
+		t.Errorf("Idle == %d, want %d without idle connections", stats.Idle, 0)
+	}
+}
+
+func TestSQLiteDaoContextCanceled(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	db.save([]byte("session"), []byte("contents"), time.Now(), time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := db.getSessionBySessionIDContext(ctx, []byte("session")); !errors.Is(err, context.Canceled) {
+		t.Errorf("getSessionBySessionIDContext() error == %v, want %v", err, context.Canceled)
+	}
+	if _, err := db.saveContext(ctx, []byte("session"), []byte("changed"), time.Now(), time.Hour); !errors.Is(err, context.Canceled) {
+		t.Errorf("saveContext() error == %v, want %v", err, context.Canceled)
+	}
+
+	row, err := db.getSessionBySessionID([]byte("session"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.contents != "contents" {
+		t.Errorf("contents == %s, want %s unchanged by the cancelled write", row.contents, "contents")
+	}
+}
+
+func TestSQLiteDaoListSessions(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		db.save([]byte(fmt.Sprintf("session%d", i)), nil, now.Add(-time.Duration(i)*time.Minute), time.Hour)
+	}
+	db.save([]byte("expired"), nil, now.Add(-2*time.Hour), time.Hour)
+
+	var ids []string
+	for offset := 0; ; offset += 2 {
+		rows, err := db.listSessions(offset, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) > 2 {
+			t.Fatalf("listSessions(%d, %d) returned %d rows, want at most %d", offset, 2, len(rows), 2)
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		for _, row := range rows {
+			ids = append(ids, row.sessionID)
+		}
+	}
+
+	want := []string{"session0", "session1", "session2", "session3", "session4"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("listSessions() ids == %v, want %v", ids, want)
+	}
+}
+
+func TestSQLiteDaoTouch(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	lastActive := time.Now().Add(-time.Minute).Truncate(time.Second)
+	db.save([]byte("session"), []byte("contents"), lastActive, time.Hour)
+
+	touched := lastActive.Add(time.Minute)
+	if n, err := db.touch([]byte("session"), touched); err != nil || n != 1 {
+		t.Errorf("touch() == %d, %v, want %d, %v", n, err, 1, nil)
+	}
+
+	row, err := db.getSessionBySessionID([]byte("session"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !row.lastActive.Equal(touched) {
+		t.Errorf("lastActive == %v, want %v", row.lastActive, touched)
+	}
+	if row.contents != "contents" {
+		t.Errorf("contents == %s, want %s", row.contents, "contents")
+	}
+
+	if _, err = db.touch([]byte("missing"), touched); err != ErrSessionNotFound {
+		t.Errorf("touch() of a missing session error == %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func TestSQLiteDaoReadReplica(t *testing.T) {
+	replica, err := NewDaoWithConfig("sqlite3", "file:replica?mode=memory&cache=shared", "session", DaoConfig{Dialect: SQLiteDialect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Close()
+
+	if err = replica.EnsureTable(); err != nil {
+		t.Fatal(err)
+	}
+	replica.save([]byte("replica"), nil, time.Now(), time.Hour)
+
+	db, err := NewDaoWithConfig("sqlite3", "file:primary?mode=memory&cache=shared", "session", DaoConfig{
+		Dialect: SQLiteDialect,
+		ReadDsn: "file:replica?mode=memory&cache=shared",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err = db.EnsureTable(); err != nil {
+		t.Fatal(err)
+	}
+	db.save([]byte("primary"), nil, time.Now(), time.Hour)
+
+	ctx := context.Background()
+	primary := WithPrimary(ctx)
+
+	if _, err = db.getSessionBySessionIDContext(ctx, []byte("replica")); err != nil {
+		t.Errorf("getSessionBySessionIDContext() of the replica error == %v, want %v", err, nil)
+	}
+	if _, err = db.getSessionBySessionIDContext(ctx, []byte("primary")); err != ErrSessionNotFound {
+		t.Errorf("getSessionBySessionIDContext() of the primary error == %v, want %v", err, ErrSessionNotFound)
+	}
+	if _, err = db.getSessionBySessionIDContext(primary, []byte("primary")); err != nil {
+		t.Errorf("getSessionBySessionIDContext(WithPrimary) error == %v, want %v", err, nil)
+	}
+	if _, err = db.getSessionBySessionIDContext(primary, []byte("replica")); err != ErrSessionNotFound {
+		t.Errorf("getSessionBySessionIDContext(WithPrimary) of the replica error == %v, want %v", err, ErrSessionNotFound)
+	}
+
+	err = db.WithTx(ctx, func(tx *Dao) error {
+		if _, err := tx.getSessionBySessionIDContext(ctx, []byte("primary")); err != nil {
+			t.Errorf("getSessionBySessionIDContext() in a transaction error == %v, want %v", err, nil)
+		}
+		if _, err := tx.getSessionBySessionIDContext(ctx, []byte("replica")); err != ErrSessionNotFound {
+			t.Errorf("getSessionBySessionIDContext() of the replica in a transaction error == %v, want %v", err, ErrSessionNotFound)
+		}
+
+		_, err := tx.saveContext(ctx, []byte("tx"), nil, time.Now(), time.Hour)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = db.save([]byte("written"), nil, time.Now(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"written", "tx"} {
+		if _, err = db.getSessionBySessionIDContext(primary, []byte(id)); err != nil {
+			t.Errorf("getSessionBySessionIDContext(WithPrimary) of %q error == %v, want %v", id, err, nil)
+		}
+	}
+	if total := replica.countSessions(); total != 1 {
+		t.Errorf("countSessions() of the replica == %d, want %d without the writes", total, 1)
+	}
+}
+
+// recordingLogger logger recording the formatted lines
+type recordingLogger struct {
+	mu     sync.Mutex
+	debugs []string
+	errors []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.debugs = append(l.debugs, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+
+func TestSQLiteDaoLogger(t *testing.T) {
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{SlowQueryThreshold: time.Nanosecond})
+	defer db.Close()
+
+	logger := &recordingLogger{}
+	db.SetLogger(logger)
+
+	db.save([]byte("session"), nil, time.Now(), time.Hour)
+	if len(logger.debugs) != 1 || !strings.Contains(logger.debugs[0], "session save slow query") {
+		t.Errorf("slow query lines == %q, want one of the save", logger.debugs)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	db.getSessionBySessionIDContext(ctx, []byte("session"))
+	if len(logger.errors) != 1 || !strings.Contains(logger.errors[0], "session get failed") {
+		t.Errorf("error lines == %q, want one of the get", logger.errors)
+	}
+
+	db.SetLogger(nil)
+	db.save([]byte("session"), nil, time.Now(), time.Hour)
+	if len(logger.debugs) != 1 {
+		t.Errorf("slow query lines == %d after SetLogger(nil), want %d", len(logger.debugs), 1)
+	}
+}
+
+func TestSQLiteDaoLoggerBelowThreshold(t *testing.T) {
+	logger := &recordingLogger{}
+
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{SlowQueryThreshold: time.Hour, Logger: logger})
+	defer db.Close()
+
+	db.save([]byte("session"), nil, time.Now(), time.Hour)
+	db.getSessionBySessionID([]byte("session"))
+
+	if len(logger.debugs) != 0 || len(logger.errors) != 0 {
+		t.Errorf("lines == %q, %q, want none below the threshold", logger.debugs, logger.errors)
+	}
+}
+
+func TestSQLiteDaoDeleteBySessionIDs(t *testing.T) {
+	for _, cfg := range []DaoConfig{{}, {MultiTenant: true}} {
+		db := getSQLiteTestDaoWithConfig(t, cfg)
+
+		now := time.Now()
+		for _, id := range []string{"a", "b", "c"} {
+			db.save([]byte(id), nil, now, time.Hour)
+		}
+		other, err := db.ForTenant("other")
+		if cfg.MultiTenant {
+			if err != nil {
+				t.Fatal(err)
+			}
+			other.save([]byte("a"), nil, now, time.Hour)
+		}
+
+		n, err := db.deleteBySessionIDs([][]byte{[]byte("a"), []byte("c"), []byte("missing")})
+		if err != nil || n != 2 {
+			t.Errorf("deleteBySessionIDs() == %d, %v, want %d", n, err, 2)
+		}
+		if _, err = db.getSessionBySessionID([]byte("b")); err != nil {
+			t.Errorf("getSessionBySessionID() of the kept session error == %v, want %v", err, nil)
+		}
+		if cfg.MultiTenant {
+			if _, err = other.getSessionBySessionID([]byte("a")); err != nil {
+				t.Errorf("getSessionBySessionID() of another tenant error == %v, want %v", err, nil)
+			}
+		}
+
+		db.Close()
+	}
+}
+
+func TestSQLiteDaoTableMissing(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	db.Connection.Exec("DROP TABLE " + db.quotedTableName)
+
+	if _, err := db.getSessionBySessionID([]byte("dropped")); !errors.Is(err, ErrTableMissing) {
+		t.Errorf("getSessionBySessionID() == %v, want %v", err, ErrTableMissing)
+	}
+}
+
+func TestSQLiteDaoTableMissingRecreated(t *testing.T) {
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{EnsureTable: true})
+	defer db.Close()
+
+	db.Connection.Exec("DROP TABLE " + db.quotedTableName)
+
+	if _, err := db.save([]byte("recreated"), []byte("data"), time.Now(), time.Hour); err != nil {
+		t.Fatalf("save() unexpected error: %v", err)
+	}
+
+	row, err := db.getSessionBySessionID([]byte("recreated"))
+	if err != nil || string(row.contents) != "data" {
+		t.Errorf("getSessionBySessionID() == %v, %v, want %s", row, err, "data")
+	}
+}
+
+func TestSQLiteDaoBinaryContents(t *testing.T) {
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{BinaryContents: true})
+	defer db.Close()
+
+	contents := []byte{0xff, 0x00, 0xfe, 'g', 'o', 'b'}
+	if _, err := db.save([]byte("binary"), contents, time.Now(), time.Hour); err != nil {
+		t.Fatalf("save() unexpected error: %v", err)
+	}
+
+	row, err := db.getSessionBySessionID([]byte("binary"))
+	if err != nil || row.contents != string(contents) {
+		t.Errorf("getSessionBySessionID() == %v, %v, want %q", row, err, contents)
+	}
+
+	if _, err := db.save([]byte("empty"), nil, time.Now(), time.Hour); err != nil {
+		t.Errorf("save() unexpected error: %v", err)
+	}
+
+	cfg := DaoConfig{Dialect: SQLiteDialect, BinaryContents: true, JSONContents: true}
+	if _, err := NewDaoWithConfig("sqlite3", ":memory:", "session", cfg); err != errJSONBinaryContents {
+		t.Errorf("NewDaoWithConfig() == %v, want %v", err, errJSONBinaryContents)
+	}
+}
+
+func TestSQLiteDaoRawQueries(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	now := time.Now()
+	db.insert([]byte("first"), nil, now, time.Hour)
+	db.insert([]byte("second"), nil, now, time.Hour)
+
+	n, err := db.Exec("UPDATE session SET expiration=$1", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("Exec() == %d, want %d", n, 2)
+	}
+
+	rows, err := db.Query("SELECT session_id FROM session ORDER BY session_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) != 2 || ids[0] != "first" || ids[1] != "second" {
+		t.Errorf("Query() == %v, want %v", ids, []string{"first", "second"})
+	}
+	rows.Close()
+
+	row, _ := db.QueryRow("SELECT session_id FROM session WHERE session_id=$1", "missing")
+	if err = row.Scan(new(string)); err != sql.ErrNoRows {
+		t.Errorf("QueryRow() error == %v, want %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestSQLiteDaoStartGC(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	db.insert([]byte("expired"), nil, time.Now().Add(-10*time.Second), 5*time.Second)
+
+	db.StartGC(context.Background(), 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	db.StopGC()
+	db.StopGC()
+
+	if total := db.countSessions(); total != 0 {
+		t.Errorf("countSessions() == %d, want %d", total, 0)
+	}
+}
+
+func TestSQLiteDaoRunGC(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := db.RunGC(ctx, 10*time.Millisecond); err != context.DeadlineExceeded {
+		t.Errorf("RunGC() == %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestSQLiteDaoGCInvalidInterval(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	db.insert([]byte("expired"), nil, time.Now().Add(-10*time.Second), 5*time.Second)
+
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if err := db.RunGC(context.Background(), interval); err != errInvalidGCInterval {
+			t.Errorf("RunGC(%v) == %v, want %v", interval, err, errInvalidGCInterval)
+		}
+
+		db.StartGC(context.Background(), interval)
+		time.Sleep(20 * time.Millisecond)
+		db.StopGC()
+	}
+
+	if total := db.countSessions(); total != 1 {
+		t.Errorf("countSessions() == %d, want %d without gc", total, 1)
+	}
+}
+
+func TestSQLiteDaoGCBatchSize(t *testing.T) {
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{GCBatchSize: 2})
+	defer db.Close()
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		db.save([]byte(fmt.Sprintf("expired%d", i)), nil, now.Add(-time.Hour), time.Minute)
+	}
+	db.save([]byte("alive"), nil, now, time.Hour)
+
+	n, locked, err := db.gcCycle(context.Background())
+	if err != nil || !locked || n != 5 {
+		t.Errorf("gcCycle() == %d, %v, %v, want %d, %v, %v", n, locked, err, 5, true, nil)
+	}
+	if total := db.countSessions(); total != 1 {
+		t.Errorf("countSessions() == %d, want %d", total, 1)
+	}
+}
+
+func TestSQLiteDaoTable(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	mobile, err := db.Table("session_mobile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = mobile.EnsureTable(); err != nil {
+		t.Fatal(err)
+	}
+
+	if cached, _ := db.Table("session_mobile"); cached != mobile {
+		t.Error("Table() expected the cached Dao of the table")
+	}
+
+	mobile.insert([]byte("mobile"), []byte("app"), time.Now(), time.Hour)
+	mobile.Close()
+
+	row, err := db.getSessionBySessionIDFrom("session_mobile", []byte("mobile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.contents != "app" {
+		t.Errorf("contents == %s, want %s", row.contents, "app")
+	}
+
+	if _, err = db.getSessionBySessionID([]byte("mobile")); err != ErrSessionNotFound {
+		t.Errorf("getSessionBySessionID() error == %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func TestSQLiteDaoTableWithTx(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	other, _ := db.Table("other")
+	if err := other.EnsureTable(); err != nil {
+		t.Fatal(err)
+	}
+	db.tables.mu.Lock()
+	delete(db.tables.daos, tableKey{tableName: "other"})
+	db.tables.mu.Unlock()
+
+	err := db.WithTx(context.Background(), func(tx *Dao) error {
+		txOther, err := tx.Table("other")
+		if err != nil {
+			return err
+		}
+		if txOther.tx == nil {
+			t.Error("Table() of a transaction scoped Dao expected to run in the transaction")
+		}
+
+		_, err = txOther.insert([]byte("in-tx"), nil, time.Now(), time.Hour)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ = db.Table("other")
+	if other.tx != nil {
+		t.Fatal("Table() returned the Dao of a committed transaction")
+	}
+	row, err := other.getSessionBySessionID([]byte("in-tx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	row.Release()
+}
+
+func TestSQLiteDaoTableForTenant(t *testing.T) {
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{MultiTenant: true})
+	defer db.Close()
+
+	acme, _ := db.ForTenant("acme")
+	globex, _ := db.ForTenant("globex")
+
+	acmeOther, err := acme.Table("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = acmeOther.EnsureTable(); err != nil {
+		t.Fatal(err)
+	}
+	acmeOther.insert([]byte("shared"), []byte("secret-of-acme"), time.Now(), time.Hour)
+
+	globexOther, err := globex.Table("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if globexOther == acmeOther {
+		t.Fatal("Table() of another tenant returned the Dao of the first tenant")
+	}
+	if globexOther.tenantID != "globex" {
+		t.Errorf("tenantID == %s, want %s", globexOther.tenantID, "globex")
+	}
+	if _, err = globexOther.getSessionBySessionID([]byte("shared")); err != ErrSessionNotFound {
+		t.Errorf("getSessionBySessionID() of another tenant error == %v, want %v", err, ErrSessionNotFound)
+	}
+
+	if cached, _ := acme.Table("other"); cached != acmeOther {
+		t.Error("Table() expected the cached Dao of the table of the tenant")
+	}
+}
+
+func TestSQLiteDaoRegenerateByCopy(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+	db.copyOnRegenerate = true
+
+	now := time.Now()
+	db.insert([]byte("old"), []byte("contents"), now, time.Hour)
+
+	n, err := db.regenerate([]byte("old"), []byte("new"), now, 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("regenerate() == %d, want %d", n, 1)
+	}
+
+	row, err := db.getSessionBySessionID([]byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.contents != "contents" || row.expiration != 2*time.Hour {
+		t.Errorf("regenerated row == %s, %s, want %s, %s", row.contents, row.expiration, "contents", 2*time.Hour)
+	}
+
+	if _, err = db.getSessionBySessionID([]byte("old")); err != ErrSessionNotFound {
+		t.Errorf("getSessionBySessionID() error == %v, want %v", err, ErrSessionNotFound)
+	}
+
+	if n, err = db.regenerate([]byte("missing"), []byte("other"), now, time.Hour); err != nil || n != 0 {
+		t.Errorf("regenerate() == %d, %v, want %d, %v", n, err, 0, nil)
+	}
+}
+
+func TestSQLiteDaoRegenerateWithContents(t *testing.T) {
+	for _, copyOnRegenerate := range []bool{false, true} {
+		db := getSQLiteTestDao(t)
+		db.copyOnRegenerate = copyOnRegenerate
+
+		now := time.Now()
+		db.insert([]byte("old"), []byte("anonymous"), now, time.Hour)
+
+		n, err := db.regenerateWithContents([]byte("old"), []byte("new"), []byte("authenticated"), now, 2*time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("regenerateWithContents() == %d, want %d", n, 1)
+		}
+
+		row, err := db.getSessionBySessionID([]byte("new"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row.contents != "authenticated" || row.expiration != 2*time.Hour {
+			t.Errorf("regenerated row == %s, %s, want %s, %s", row.contents, row.expiration, "authenticated", 2*time.Hour)
+		}
+		row.Release()
+
+		if _, err = db.getSessionBySessionID([]byte("old")); err != ErrSessionNotFound {
+			t.Errorf("getSessionBySessionID() error == %v, want %v", err, ErrSessionNotFound)
+		}
+
+		db.Close()
+	}
+}
+
+func TestSQLiteDaoRegenerateKeepContents(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	lastActive := time.Now().Add(-time.Minute)
+	db.insert([]byte("old"), []byte("contents"), lastActive, time.Hour)
+
+	if _, err := db.regenerateKeepContents([]byte("old"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	row, err := db.getSessionBySessionID([]byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.contents != "contents" || row.lastActive.Unix() != lastActive.Unix() || row.expiration != time.Hour {
+		t.Errorf("regenerated row == %s, %d, %s, want %s, %d, %s", row.contents, row.lastActive.Unix(), row.expiration, "contents", lastActive.Unix(), time.Hour)
+	}
+
+	if total := db.countSessions(); total != 1 {
+		t.Errorf("countSessions() == %d, want %d", total, 1)
+	}
+}
+
+func TestSQLiteDaoWithDB(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+
+	db, err := NewDaoWithDBConfig(conn, "session", DaoConfig{Dialect: SQLiteDialect, EnsureTable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = db.insert([]byte("shared"), nil, time.Now(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if err = conn.Ping(); err != nil {
+		t.Errorf("Ping() unexpected error after closing the Dao: %v", err)
+	}
+}
+
+func TestSQLiteDaoCreatedAt(t *testing.T) {
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{CreatedAt: true})
+	defer db.Close()
+
+	createdAt := time.Now().Add(-time.Minute)
+	sessionID := []byte("created")
+	db.insert(sessionID, nil, createdAt, time.Hour)
+	db.updateBySessionID(sessionID, []byte("updated"), createdAt.Add(30*time.Second), time.Hour)
+	db.regenerateKeepContents(sessionID, []byte("regenerated"))
+
+	row, err := db.getSessionBySessionID([]byte("regenerated"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.CreatedAt().Unix() != createdAt.Unix() {
+		t.Errorf("CreatedAt() == %d, want %d", row.CreatedAt().Unix(), createdAt.Unix())
+	}
+	if row.LastActive().Unix() != createdAt.Unix()+30 {
+		t.Errorf("LastActive() == %d, want %d", row.LastActive().Unix(), createdAt.Unix()+30)
+	}
+}
+
+func TestSQLiteDaoDeleteByContentsField(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	now := time.Now()
+	db.insert([]byte("match"), []byte("user=42_a"), now, time.Hour)
+	db.insert([]byte("wildcard"), []byte("user=42xa"), now, time.Hour)
+
+	n, err := db.deleteByContentsField("user", "42_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("deleteByContentsField() == %d, want %d", n, 1)
+	}
+
+	if _, err = db.getSessionBySessionID([]byte("wildcard")); err != nil {
+		t.Errorf("getSessionBySessionID() unexpected error: %v", err)
+	}
+}
+
+func TestSQLiteDaoTimeUnit(t *testing.T) {
+	db := getSQLiteTestDaoWithConfig(t, DaoConfig{TimeUnit: time.Millisecond})
+	defer db.Close()
+
+	db.insert([]byte("csrf"), nil, time.Now(), 200*time.Millisecond)
+	db.insert([]byte("expired"), nil, time.Now().Add(-300*time.Millisecond), 200*time.Millisecond)
+
+	row, err := db.getSessionBySessionID([]byte("csrf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.expiration != 200*time.Millisecond {
+		t.Errorf("expiration == %s, want %s", row.expiration, 200*time.Millisecond)
+	}
+
+	if n, _ := db.deleteExpiredSessions(); n != 1 {
+		t.Errorf("deleteExpiredSessions() == %d, want %d", n, 1)
+	}
+}
+
+func TestDaoUnits(t *testing.T) {
+	db := &Dao{timeUnit: time.Second}
+
+	if n := db.units(500 * time.Millisecond); n != 1 {
+		t.Errorf("units() == %d, want %d", n, 1)
+	}
+	if n := db.units(2 * time.Second); n != 2 {
+		t.Errorf("units() == %d, want %d", n, 2)
+	}
+	if n := db.units(SessionNeverExpires); n != 0 {
+		t.Errorf("units() == %d, want %d", n, 0)
+	}
+	if n := db.units(-time.Second); n != 0 {
+		t.Errorf("units() == %d, want %d", n, 0)
+	}
+	if n := db.units(time.Nanosecond); n != 1 {
+		t.Errorf("units() == %d, want %d", n, 1)
+	}
+}
+
+func TestSQLiteDaoExpirationRoundTrip(t *testing.T) {
+	for _, unit := range []time.Duration{time.Second, time.Millisecond} {
+		db := getSQLiteTestDaoWithConfig(t, DaoConfig{TimeUnit: unit})
+
+		lastActive := time.Now().Add(-time.Minute)
+		db.insert([]byte("expiring"), nil, lastActive, 90*time.Second)
+		db.insert([]byte("expired"), nil, lastActive, 30*time.Second)
+
+		row, err := db.getSessionBySessionID([]byte("expiring"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !row.LastActive().Equal(lastActive.Truncate(unit)) || row.Expiration() != 90*time.Second {
+			t.Errorf("unit %s: row == %s, %s, want %s, %s", unit, row.LastActive(), row.Expiration(), lastActive.Truncate(unit), 90*time.Second)
+		}
+
+		if n, err := db.deleteExpiredSessions(); err != nil || n != 1 {
+			t.Errorf("unit %s: deleteExpiredSessions() == %d, %v, want %d, %v", unit, n, err, 1, nil)
+		}
+
+		db.Close()
+	}
+}
+
+func TestSQLiteDaoIterate(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	for _, id := range []string{"a", "b", "c"} {
+		db.insert([]byte(id), []byte(id), time.Now(), time.Hour)
+	}
+
+	seen := make(map[string]bool)
+	err := db.iterate(func(row *DBRow) error {
+		if row.contents != row.sessionID {
+			t.Errorf("contents == %s, want %s", row.contents, row.sessionID)
+		}
+		seen[row.sessionID] = true
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 3 {
+		t.Errorf("iterated sessions == %v, want %d", seen, 3)
+	}
+
+	errStop := errors.New("stop")
+	calls := 0
+	err = db.iterate(func(row *DBRow) error {
+		calls++
+		return errStop
+	})
+	if err != errStop || calls != 1 {
+		t.Errorf("iterate() == %v after %d calls, want %v after %d", err, calls, errStop, 1)
+	}
+}
+
+func TestSQLiteDaoDeleteAll(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	db.insert([]byte("a"), nil, time.Now(), time.Hour)
+	db.insert([]byte("b"), nil, time.Now(), 0)
+
+	n, err := db.deleteAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("deleteAll() == %d, want %d", n, 2)
+	}
+
+	if total := db.countSessions(); total != 0 {
+		t.Errorf("countSessions() == %d, want %d", total, 0)
+	}
+}
@@ -0,0 +1,177 @@
+package postgres
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PostgresDialect postgres sql dialect, used by default
+var PostgresDialect Dialect = postgresDialect{}
+
+// MySQLDialect mysql sql dialect
+//
+// It covers the basic session statements, save upserting with ON DUPLICATE KEY UPDATE.
+// The statements with RETURNING, like the one of the sliding expiration, run as several ones
+// in a transaction. The multi-tenant, created at and notify modes are rejected by the Dao
+var MySQLDialect Dialect = mysqlDialect{}
+
+// SQLiteDialect sqlite sql dialect, meant for local and testing deployments
+//
+// It supports the upsert of save but not RETURNING, the statements using it,
+// like the one of the sliding expiration, run as several ones in a transaction
+var SQLiteDialect Dialect = sqliteDialect{}
+
+// CockroachDialect cockroachdb sql dialect, postgres compatible
+//
+// It regenerates the session ids copying the row to the new id and deleting the old one
+// in a transaction, instead of updating the primary key in place
+var CockroachDialect Dialect = cockroachDialect{}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (postgresDialect) QuoteIdentifier(name string) string {
+	return quoteIdentifier(name)
+}
+
+type cockroachDialect struct {
+	postgresDialect
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (mysqlDialect) QuoteIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Placeholder(n int) string {
+	return "?" + strconv.Itoa(n)
+}
+
+func (sqliteDialect) QuoteIdentifier(name string) string {
+	return quoteIdentifier(name)
+}
+
+// isPostgresCompatible report whether the dialect is postgres or a postgres compatible one
+func isPostgresCompatible(d Dialect) bool {
+	switch d.(type) {
+	case postgresDialect, cockroachDialect:
+		return true
+	}
+
+	return false
+}
+
+// isPositional report whether the dialect binds the parameters by position,
+// like the ? of mysql, instead of by number
+func isPositional(d Dialect) bool {
+	return d.Placeholder(1) == d.Placeholder(2)
+}
+
+// rebind rewrite the $n placeholders of the query to the ones of the dialect,
+// leaving the quoted identifiers and literals untouched
+func rebind(d Dialect, query string) string {
+	if isPostgresCompatible(d) {
+		return query
+	}
+
+	query, _ = rebindOrder(d, query)
+
+	return query
+}
+
+// rebindOrder rewrite the $n placeholders of the query like rebind
+// and return their numbers in order of appearance
+func rebindOrder(d Dialect, query string) (string, []int) {
+	var b strings.Builder
+	var order []int
+	var quote byte
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '$' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9':
+			j := i + 1
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				j++
+			}
+
+			n, _ := strconv.Atoi(query[i+1 : j])
+			b.WriteString(d.Placeholder(n))
+			order = append(order, n)
+			i = j - 1
+
+			continue
+		}
+
+		b.WriteByte(c)
+	}
+
+	return b.String(), order
+}
+
+// isSequential report whether the placeholders appear once each in ascending order,
+// needing no reordering of the arguments by a positional dialect
+func isSequential(order []int) bool {
+	for i, n := range order {
+		if n != i+1 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// reorderArgs return the arguments of the $n parameters in the given order,
+// repeating the ones of the parameters used more than once
+func reorderArgs(order []int, args []interface{}) []interface{} {
+	ordered := make([]interface{}, len(order))
+
+	for i, n := range order {
+		if n > len(args) {
+			return args // let the driver report the missing arguments
+		}
+		ordered[i] = args[n-1]
+	}
+
+	return ordered
+}
+
+// maxPlaceholder return the highest $n placeholder of the query, 0 without any
+func maxPlaceholder(query string) int {
+	max := 0
+
+	for i := 0; i < len(query); i++ {
+		if query[i] != '$' {
+			continue
+		}
+
+		j := i + 1
+		for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+			j++
+		}
+
+		if n, err := strconv.Atoi(query[i+1 : j]); err == nil && n > max {
+			max = n
+		}
+		i = j - 1
+	}
+
+	return max
+}
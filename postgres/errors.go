@@ -1,7 +1,125 @@
 package postgres
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// ErrSessionNotFound returned when the session does not exist, match it with errors.Is
+var ErrSessionNotFound = errors.New("Session not found")
+
+// ErrSessionIDConflict returned when the new session id already exists, match it with errors.Is
+var ErrSessionIDConflict = errors.New("Session id already exists")
+
+// ErrConcurrentModification returned when the session has been updated since it was read
+var ErrConcurrentModification = errors.New("Session modified concurrently")
+
+// ErrTableMissing returned when the session table does not exist,
+// like when it is dropped or renamed while running, match it with errors.Is
+var ErrTableMissing = errors.New("Session table does not exist")
+
+// ErrCircuitOpen returned without querying while the circuit breaker of the database is open,
+// after consecutive lost connections, match it with errors.Is
+var ErrCircuitOpen = errors.New("Session database circuit is open")
 
 var errInvalidProviderConfig = errors.New("Invalid provider config")
+var errTableNameEmpty = errors.New("Table name must not be empty")
+var errDsnEmpty = errors.New("Dsn must not be empty")
 var errConfigHostEmpty = errors.New("Config Host must not be empty")
 var errConfigPortZero = errors.New("Config Port must be more than 0")
+var errInvalidEncryptionKey = errors.New("Encryption key must be 32 bytes long")
+var errInvalidEncryptedContents = errors.New("Invalid encrypted session contents")
+var errInvalidJSONContents = errors.New("Session contents must be valid JSON")
+var errJSONContentsDisabled = errors.New("JSON contents mode is not enabled")
+var errExpiresAtDisabled = errors.New("Expires at mode is not enabled")
+var errMultiTenantDisabled = errors.New("Multi-tenant mode is not enabled")
+var errNotifyDisabled = errors.New("Notify channel is not configured")
+var errNotifyNoDsn = errors.New("Invalidations listener requires the dsn of the Dao")
+var errPartitionsDisabled = errors.New("Partitioned mode is not enabled")
+var errPartitionsUnsupported = errors.New("Partitioned tables are only supported by the postgres dialect")
+var errGCAdvisoryLockUnsupported = errors.New("GC advisory lock is only supported by the postgres dialect")
+var errSearchPathUnsupported = errors.New("Search path is only supported by the postgres compatible dialects")
+var errSearchPathConnection = errors.New("Driver connection can not set the search path")
+var errInvalidGCInterval = errors.New("GC interval must be positive")
+var errInvalidBatchSize = errors.New("Batch size must be positive")
+var errNegativeMaxSessions = errors.New("Maximum sessions must not be negative")
+var errCopyUnsupported = errors.New("COPY FROM is only supported by the postgres compatible dialects")
+var errContentsNotSearchable = errors.New("Encrypted or compressed contents can not be searched")
+var errJSONContentsEncoding = errors.New("JSON contents can not be encrypted or compressed")
+var errJSONBinaryContents = errors.New("JSON contents can not be stored as binary")
+var errGobTextContents = errors.New("Gob codec requires the binary or the encrypted contents")
+var errJSONContentsVersion = errors.New("JSON contents can not be versioned")
+
+func errUnknownDriver(name string) error {
+	return fmt.Errorf("Unknown sql driver %q, forgotten import?", name)
+}
+
+func errMySQLUnsupported(option string) error {
+	return fmt.Errorf("Config %s is not supported by the mysql dialect", option)
+}
+
+func errUnknownContentsVersion(version byte) error {
+	return fmt.Errorf("Unknown session contents version %d", version)
+}
+
+func errMissingContentsUpgrade(version byte) error {
+	return fmt.Errorf("Missing session contents upgrade of the version %d", version)
+}
+
+func errInvalidIdentifier(name string) error {
+	return fmt.Errorf("Invalid sql identifier %q", name)
+}
+
+// sqlStater error of a driver reporting its sqlstate, like the *pgconn.PgError of pgx
+type sqlStater interface {
+	SQLState() string
+}
+
+// sqlState return the postgres sqlstate of the error of lib/pq or pgx, empty for the other errors
+func sqlState(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code)
+	}
+
+	var stater sqlStater
+	if errors.As(err, &stater) {
+		return stater.SQLState()
+	}
+
+	return ""
+}
+
+// isUniqueViolation report whether err is a unique constraint violation,
+// by its sqlstate for postgres and its message for sqlite and mysql
+func isUniqueViolation(err error) bool {
+	if state := sqlState(err); state != "" || err == nil {
+		return state == "23505" // unique_violation
+	}
+
+	msg := err.Error()
+
+	return strings.Contains(msg, "UNIQUE constraint failed") || strings.Contains(msg, "Error 1062:") // ER_DUP_ENTRY
+}
+
+// isTableMissing report whether err is a missing table error,
+// by its sqlstate for postgres and its message for sqlite
+func isTableMissing(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if state := sqlState(err); state != "" {
+		return state == "42P01" // undefined_table
+	}
+
+	return strings.HasPrefix(err.Error(), "no such table")
+}
+
+// isInsufficientPrivilege report whether err is a missing privilege error
+func isInsufficientPrivilege(err error) bool {
+	return sqlState(err) == "42501" // insufficient_privilege
+}
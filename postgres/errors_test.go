@@ -0,0 +1,101 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestIsUniqueViolation(t *testing.T) {
+	if !isUniqueViolation(&pq.Error{Code: "23505"}) {
+		t.Error("isUniqueViolation() == false, want true")
+	}
+	if !isUniqueViolation(errors.New("session insert: UNIQUE constraint failed: session.session_id")) {
+		t.Error("isUniqueViolation() == false, want true")
+	}
+	if !isUniqueViolation(errors.New("session insert: Error 1062: Duplicate entry 'id' for key 'PRIMARY'")) {
+		t.Error("isUniqueViolation() == false, want true")
+	}
+	if isUniqueViolation(&pq.Error{Code: "40001"}) || isUniqueViolation(nil) {
+		t.Error("isUniqueViolation() == true, want false")
+	}
+}
+
+func TestIsInsufficientPrivilege(t *testing.T) {
+	if !isInsufficientPrivilege(&pq.Error{Code: "42501"}) {
+		t.Error("isInsufficientPrivilege() == false, want true")
+	}
+	if isInsufficientPrivilege(nil) {
+		t.Error("isInsufficientPrivilege() == true, want false")
+	}
+}
+
+// stateError error reporting its sqlstate like the *pgconn.PgError of pgx
+type stateError string
+
+func (e stateError) Error() string {
+	return "ERROR: " + string(e)
+}
+
+func (e stateError) SQLState() string {
+	return string(e)
+}
+
+func TestSQLState(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected string
+	}{
+		{&pq.Error{Code: "23505"}, "23505"},
+		{fmt.Errorf("session insert: %w", &pq.Error{Code: "40001"}), "40001"},
+		{stateError("42P01"), "42P01"},
+		{fmt.Errorf("session get: %w", stateError("57P01")), "57P01"},
+		{errors.New("no such table: session"), ""},
+		{nil, ""},
+	}
+
+	for _, c := range cases {
+		if state := sqlState(c.err); state != c.expected {
+			t.Errorf("sqlState(%v) == %q, want %q", c.err, state, c.expected)
+		}
+	}
+
+	if !isUniqueViolation(stateError("23505")) || !isRetryable(stateError("40P01")) || !isConnectionLost(stateError("08006")) {
+		t.Error("the pgx errors are not classified by their sqlstate")
+	}
+}
+
+func TestIsTableMissing(t *testing.T) {
+	if !isTableMissing(&pq.Error{Code: "42P01"}) {
+		t.Error("isTableMissing() == false, want true")
+	}
+	if !isTableMissing(errors.New("no such table: session")) {
+		t.Error("isTableMissing() == false, want true")
+	}
+	if isTableMissing(&pq.Error{Code: "42501"}) || isTableMissing(nil) {
+		t.Error("isTableMissing() == true, want false")
+	}
+}
+
+func TestRunWrapsErrors(t *testing.T) {
+	db := &Dao{logger: noopLogger{}}
+
+	pqErr := &pq.Error{Code: "23505"}
+	err := db.run(context.Background(), opInsert, "", func() error {
+		return pqErr
+	})
+	if err == nil || err.Error() != "session insert: "+pqErr.Error() {
+		t.Errorf("run() error == %v, want %s", err, "session insert: "+pqErr.Error())
+	}
+	if !errors.Is(err, pqErr) || !isUniqueViolation(err) {
+		t.Errorf("run() error == %v, want it wrapping %v", err, pqErr)
+	}
+
+	if err = db.run(context.Background(), opGet, "", func() error { return sql.ErrNoRows }); err != sql.ErrNoRows {
+		t.Errorf("run() error == %v, want %v", err, sql.ErrNoRows)
+	}
+}
@@ -0,0 +1,165 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync"
+	"time"
+)
+
+// failoverConnector connector of the pool trying the dsns in order, the preferred first
+//
+// A dsn found down is skipped by the next connections until the probe interval
+// has elapsed, then probed again, so the pool moves back to the preferred dsn
+// as its connections are recycled
+type failoverConnector struct {
+	driver        driver.Driver
+	dsns          []string
+	probeInterval time.Duration
+
+	mu   sync.Mutex
+	down []time.Time
+}
+
+// NewDaoWithFailover create new database access object connecting to the first reachable dsn,
+// in order of preference, with the given configuration
+//
+// Every new connection of the pool tries the preferred dsns first, reusing the
+// health check ping to decide their liveness, and a dsn found down is probed again
+// after the FailoverProbeInterval of the configuration. The pool comes back to
+// the preferred dsn as ConnMaxLifetime recycles its connections.
+// The invalidations listener and the read replica are not covered by the failover
+func NewDaoWithFailover(driverName string, dsns []string, tableName string, cfg DaoConfig) (*Dao, error) {
+	if err := validateDriver(driverName); err != nil {
+		return nil, err
+	}
+	if len(dsns) == 0 {
+		return nil, errDsnEmpty
+	}
+	for _, dsn := range dsns {
+		if dsn == "" {
+			return nil, errDsnEmpty
+		}
+	}
+
+	db, err := newDao(tableName, cfg)
+	if err != nil {
+		return nil, err
+	}
+	db.Driver = driverName
+	dsns = append([]string(nil), dsns...)
+	for i := range dsns {
+		dsns[i] = db.withServerOptions(dsns[i])
+	}
+	db.Dsn = dsns[0]
+
+	conn, err := sql.Open(driverName, dsns[0])
+	if err != nil {
+		return nil, err
+	}
+	drv := conn.Driver()
+	conn.Close()
+
+	probeInterval := cfg.FailoverProbeInterval
+	if probeInterval == 0 {
+		probeInterval = defaultFailoverProbeInterval
+	}
+
+	db.Connection = sql.OpenDB(&failoverConnector{
+		driver:        drv,
+		dsns:          dsns,
+		probeInterval: probeInterval,
+		down:          make([]time.Time, len(dsns)),
+	})
+
+	if err = db.connect(cfg); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Connect open a connection to the first reachable dsn
+//
+// The dsns found down recently are only tried when none of the others is reachable
+func (c *failoverConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	var lastErr error
+
+	skipped := make([]int, 0, len(c.dsns))
+	for i := range c.dsns {
+		if c.isDown(i) {
+			skipped = append(skipped, i)
+			continue
+		}
+
+		conn, err := c.open(ctx, i)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+
+	for _, i := range skipped {
+		conn, err := c.open(ctx, i)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// Driver return the driver of the dsns
+func (c *failoverConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// open open and ping a connection to the i-th dsn, recording whether it is down
+func (c *failoverConnector) open(ctx context.Context, i int) (driver.Conn, error) {
+	conn, err := c.openConn(ctx, c.dsns[i])
+	if err == nil {
+		if pinger, ok := conn.(driver.Pinger); ok {
+			if err = pinger.Ping(ctx); err != nil {
+				conn.Close()
+			}
+		}
+	}
+
+	c.mu.Lock()
+	if err != nil {
+		c.down[i] = time.Now()
+	} else {
+		c.down[i] = time.Time{}
+	}
+	c.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// openConn open a connection to the dsn, through the driver connector when there is one
+func (c *failoverConnector) openConn(ctx context.Context, dsn string) (driver.Conn, error) {
+	if dc, ok := c.driver.(driver.DriverContext); ok {
+		connector, err := dc.OpenConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+
+		return connector.Connect(ctx)
+	}
+
+	return c.driver.Open(dsn)
+}
+
+// isDown report whether the i-th dsn has been found down within the probe interval
+func (c *failoverConnector) isDown(i int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return !c.down[i].IsZero() && time.Since(c.down[i]) < c.probeInterval
+}
@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNewDaoWithFailover(t *testing.T) {
+	dsns := []string{"file:/nonexistent/session.db?mode=ro", ":memory:"}
+
+	db, err := NewDaoWithFailover("sqlite3", dsns, "session", DaoConfig{
+		MaxOpenConns:     1,
+		VerifyConnection: true,
+		EnsureTable:      true,
+		Dialect:          SQLiteDialect,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err = db.insert([]byte("failover"), nil, time.Now(), time.Hour); err != nil {
+		t.Errorf("insert() unexpected error: %v", err)
+	}
+
+	if _, err = NewDaoWithFailover("sqlite3", nil, "session", DaoConfig{}); err != errDsnEmpty {
+		t.Errorf("NewDaoWithFailover() error == %v, want %v", err, errDsnEmpty)
+	}
+}
+
+func TestFailoverConnectorProbe(t *testing.T) {
+	db, err := NewDaoWithFailover("sqlite3", []string{":memory:"}, "session", DaoConfig{Dialect: SQLiteDialect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	c := &failoverConnector{
+		driver:        db.Connection.Driver(),
+		dsns:          []string{"file:/nonexistent/session.db?mode=ro", ":memory:"},
+		probeInterval: time.Hour,
+		down:          make([]time.Time, 2),
+	}
+
+	for i := 0; i < 2; i++ {
+		conn, err := c.Connect(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+
+	if !c.isDown(0) || c.isDown(1) {
+		t.Errorf("isDown() == %v, %v, want %v, %v", c.isDown(0), c.isDown(1), true, false)
+	}
+
+	c.probeInterval = 0
+	if c.isDown(0) {
+		t.Error("isDown() == true, want the dsn probed again")
+	}
+}
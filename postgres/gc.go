@@ -0,0 +1,164 @@
+package postgres
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"time"
+)
+
+// StartGC start a background goroutine deleting the expired sessions every interval,
+// until ctx is cancelled or StopGC is called
+//
+// A running garbage collector is stopped first, so it is safe to call it again to change the interval.
+// It is not available on the transaction scoped Dao, and a non positive interval is logged and ignored
+func (db *Dao) StartGC(ctx context.Context, interval time.Duration) {
+	if db.gc == nil {
+		return
+	}
+	if interval <= 0 {
+		db.logger.Errorf("session gc not started: %v", errInvalidGCInterval)
+		return
+	}
+
+	db.StopGC()
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	db.gc.mu.Lock()
+	db.gc.cancel = cancel
+	db.gc.done = done
+	db.gc.mu.Unlock()
+
+	go func() {
+		defer close(done)
+
+		err := db.RunGC(ctx, interval)
+		db.logger.Debugf("session gc stopped: %v", err)
+	}()
+}
+
+// StopGC stop the background garbage collector, waiting for its current cycle to finish
+//
+// It does nothing when the garbage collector is not running
+func (db *Dao) StopGC() {
+	if db.gc == nil {
+		return
+	}
+
+	db.gc.mu.Lock()
+	cancel, done := db.gc.cancel, db.gc.done
+	db.gc.cancel, db.gc.done = nil, nil
+	db.gc.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	<-done
+}
+
+// RunGC delete the expired sessions every interval until ctx is cancelled,
+// returning its error
+//
+// Every cycle is bound to a timeout of one interval, so a stuck statement does not block the next ones.
+// The interval must be positive
+func (db *Dao) RunGC(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return errInvalidGCInterval
+	}
+
+	timer := time.NewTimer(db.gcInterval(interval))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		cycleCtx, cancel := context.WithTimeout(ctx, interval)
+		n, locked, err := db.gcCycle(cycleCtx)
+		cancel()
+
+		if err == nil && locked {
+			db.logger.Debugf("session gc reclaimed %d expired sessions", n)
+		} else if err == nil {
+			db.logger.Debugf("session gc skipped, the lock is held by another instance")
+		}
+
+		timer.Reset(db.gcInterval(interval))
+	}
+}
+
+// gcInterval return the interval plus a random jitter when enabled
+func (db *Dao) gcInterval(interval time.Duration) time.Duration {
+	if db.gcJitter <= 0 {
+		return interval
+	}
+
+	return interval + time.Duration(rand.Int63n(int64(db.gcJitter)))
+}
+
+// gcCycle delete the expired sessions, holding the advisory lock of the table when enabled,
+// after dropping the partitions older than the retention of a partitioned table
+//
+// It reports whether the cycle ran, false when the lock is held by another instance
+func (db *Dao) gcCycle(ctx context.Context) (int64, bool, error) {
+	if !db.gcAdvisoryLock {
+		if err := db.gcPartitions(ctx); err != nil {
+			return 0, true, err
+		}
+
+		n, err := db.gcExpiredContext(ctx)
+		return n, true, err
+	}
+
+	var n int64
+	var locked bool
+
+	err := db.WithTx(ctx, func(tx *Dao) error {
+		err := tx.run(ctx, opGCLock, tx.sqlTryGCLock, func() error {
+			row, err := tx.queryRowContext(ctx, tx.sqlTryGCLock, tx.gcLockKey)
+			if err != nil {
+				return err
+			}
+
+			return row.Scan(&locked)
+		})
+		if err != nil || !locked {
+			return err
+		}
+
+		if err = tx.gcPartitions(ctx); err != nil {
+			return err
+		}
+
+		n, err = tx.gcExpiredContext(ctx)
+
+		return err
+	})
+
+	return n, locked, err
+}
+
+// gcExpiredContext delete the expired sessions of a gc cycle, in chunks of the gc batch size
+// when configured and no OnExpire callback needs the deleted sessions
+func (db *Dao) gcExpiredContext(ctx context.Context) (int64, error) {
+	if db.gcBatchSize > 0 && db.onExpire == nil {
+		return db.deleteExpiredSessionsBatchContext(ctx, db.gcBatchSize)
+	}
+
+	return db.deleteExpiredSessionsContext(ctx)
+}
+
+// advisoryLockKey return the advisory lock key of the gc of the table
+func advisoryLockKey(table string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("session gc " + table))
+
+	return int64(h.Sum64())
+}
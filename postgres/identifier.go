@@ -0,0 +1,120 @@
+package postgres
+
+import (
+	"strings"
+)
+
+// parseQualifiedName split a table name in its optional schema and its name
+//
+// Unquoted parts must be plain identifiers and are folded to lower case,
+// like postgres does, while double quoted parts are kept verbatim
+func parseQualifiedName(name string) ([]string, error) {
+	var parts []string
+
+	rest := name
+	for {
+		var part string
+		var err error
+
+		if strings.HasPrefix(rest, `"`) {
+			part, rest, err = parseQuotedIdentifier(rest)
+		} else {
+			part, rest, err = parseUnquotedIdentifier(rest)
+		}
+		if err != nil {
+			return nil, errInvalidIdentifier(name)
+		}
+
+		parts = append(parts, part)
+
+		if rest == "" {
+			break
+		}
+		if rest[0] != '.' || len(parts) == 2 {
+			return nil, errInvalidIdentifier(name)
+		}
+		rest = rest[1:]
+	}
+
+	return parts, nil
+}
+
+// parseQuotedIdentifier read a double quoted identifier from the start of s
+func parseQuotedIdentifier(s string) (string, string, error) {
+	var b strings.Builder
+
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' && i+1 < len(s) && s[i+1] == '"':
+			b.WriteByte('"')
+			i++
+		case c == '"':
+			if b.Len() == 0 {
+				return "", "", errInvalidIdentifier(s)
+			}
+			return b.String(), s[i+1:], nil
+		case c == 0:
+			return "", "", errInvalidIdentifier(s)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", "", errInvalidIdentifier(s)
+}
+
+// parseUnquotedIdentifier read a plain identifier from the start of s
+func parseUnquotedIdentifier(s string) (string, string, error) {
+	i := 0
+	for i < len(s) && isIdentifierChar(s[i], i == 0) {
+		i++
+	}
+
+	if i == 0 {
+		return "", "", errInvalidIdentifier(s)
+	}
+
+	return strings.ToLower(s[:i]), s[i:], nil
+}
+
+// validateIdentifier check the name is a plain unquoted identifier, safe to be interpolated
+// in sql as is: letters, underscores and digits or dollars after the first character
+func validateIdentifier(name string) error {
+	if name == "" {
+		return errInvalidIdentifier(name)
+	}
+
+	for i := 0; i < len(name); i++ {
+		if !isIdentifierChar(name[i], i == 0) {
+			return errInvalidIdentifier(name)
+		}
+	}
+
+	return nil
+}
+
+func isIdentifierChar(c byte, first bool) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		return true
+	case c >= '0' && c <= '9', c == '$':
+		return !first
+	}
+
+	return false
+}
+
+// quoteIdentifier quote a single identifier to be safely interpolated in sql
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// quoteQualifiedName quote every part of a parsed qualified name with the dialect
+func quoteQualifiedName(d Dialect, parts []string) string {
+	quoted := make([]string, len(parts))
+	for i, part := range parts {
+		quoted[i] = d.QuoteIdentifier(part)
+	}
+
+	return strings.Join(quoted, ".")
+}
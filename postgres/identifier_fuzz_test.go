@@ -0,0 +1,46 @@
+//go:build go1.18
+// +build go1.18
+
+package postgres
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func FuzzValidateIdentifier(f *testing.F) {
+	for _, seed := range []string{"session_id", "sid; DROP TABLE users;--", `"quoted"`, "a.b", "1col", ""} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		if validateIdentifier(name) != nil {
+			return
+		}
+
+		if strings.ContainsAny(name, " \t\r\n;'\"-./*()=,\x00") {
+			t.Errorf("validateIdentifier(%q) accepted a sql metacharacter", name)
+		}
+	})
+}
+
+func FuzzParseQualifiedName(f *testing.F) {
+	for _, seed := range []string{"sessions", "auth.sessions", `"my schema"."sessions"`, `auth."Web""Sessions"`, "sessions; DROP TABLE users;--", `"a"";DROP TABLE users;--"`} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		parts, err := parseQualifiedName(name)
+		if err != nil {
+			return
+		}
+
+		// the quoted name must read back as the very same identifiers, nothing escaping the quotes
+		quoted := quoteQualifiedName(PostgresDialect, parts)
+		reparsed, err := parseQualifiedName(quoted)
+		if err != nil || !reflect.DeepEqual(reparsed, parts) {
+			t.Errorf("parseQualifiedName(%q) == %q, %v, want %q", quoted, reparsed, err, parts)
+		}
+	})
+}
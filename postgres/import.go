@@ -0,0 +1,120 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+	"github.com/savsgio/gotils"
+)
+
+// bulk import the sessions streamed by rows with COPY FROM, in a single transaction
+//
+// It returns the amount of imported sessions once rows is closed
+func (db *Dao) bulkImport(rows <-chan *DBRow) (int64, error) {
+	return db.bulkImportContext(context.Background(), rows)
+}
+
+// bulk import the sessions streamed by rows with COPY FROM, in a single transaction bound to ctx
+//
+// It requires lib/pq and a postgres compatible dialect. The times and the expiration
+// are converted like insert does, the absolute deadline is kept and the creation time defaults to the last activity. On failure the rows are not read anymore
+// and nothing is imported, so the producer should stop sending as well
+func (db *Dao) bulkImportContext(ctx context.Context, rows <-chan *DBRow) (int64, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opImport)
+	defer cancel()
+
+	if !isPostgresCompatible(db.dialect) {
+		return 0, errCopyUnsupported
+	}
+
+	var total int64
+
+	err := db.WithTx(ctx, func(tx *Dao) error {
+		query := tx.copyInQuery()
+
+		return tx.run(ctx, opImport, query, func() error {
+			stmt, err := tx.tx.PrepareContext(ctx, query)
+			if err != nil {
+				return err
+			}
+			defer stmt.Close()
+
+			for {
+				var row *DBRow
+				var ok bool
+
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case row, ok = <-rows:
+				}
+				if !ok {
+					break
+				}
+
+				if err = tx.copyRow(ctx, stmt, row); err != nil {
+					return err
+				}
+				total++
+			}
+
+			_, err = stmt.ExecContext(ctx)
+
+			return err
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// copyInQuery return the COPY FROM statement of the session columns
+func (db *Dao) copyInQuery() string {
+	c := db.columns
+	columns := []string{c.SessionID, c.Contents, c.LastActive, c.Expiration}
+	if db.expiresAt {
+		columns = append(columns, c.ExpiresAt)
+	}
+	if db.createdAt {
+		columns = append(columns, c.CreatedAt)
+	}
+	if db.multiTenant {
+		columns = append(columns, c.TenantID)
+	}
+
+	if len(db.tableParts) > 1 {
+		return pq.CopyInSchema(db.tableParts[0], db.tableParts[1], columns...)
+	}
+
+	return pq.CopyIn(db.tableParts[0], columns...)
+}
+
+// copyRow send a session row to the COPY FROM statement
+func (db *Dao) copyRow(ctx context.Context, stmt *sql.Stmt, row *DBRow) error {
+	contents, err := db.encodeContents(gotils.S2B(row.contents))
+	if err != nil {
+		return err
+	}
+
+	args := []interface{}{row.sessionID, db.contentsArg(contents), db.unixTime(row.lastActive), db.units(row.expiration)}
+	if db.expiresAt {
+		args = append(args, db.unixTime(row.expiresAt))
+	}
+	if db.createdAt {
+		createdAt := row.createdAt
+		if createdAt.IsZero() {
+			createdAt = row.lastActive
+		}
+		args = append(args, db.unixTime(createdAt))
+	}
+	if db.multiTenant {
+		args = append(args, db.tenantID)
+	}
+
+	_, err = stmt.ExecContext(ctx, args...)
+
+	return err
+}
@@ -0,0 +1,124 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/savsgio/gotils"
+)
+
+const notifyMinReconnectInterval = 10 * time.Second
+const notifyMaxReconnectInterval = time.Minute
+const notifyPingInterval = 90 * time.Second
+
+// SubscribeInvalidations listen to the session ids deleted or regenerated by every node,
+// until ctx is cancelled and the channel is closed
+//
+// An empty id invalidates all the sessions, after a write of many of them like the gc
+// or deleteAll. It requires the notify channel configuration and the dsn of the Dao,
+// the ones built from a pool or a connector have none to open the listener with.
+// The connection is re-established when lost, the invalidations sent in between are missed
+func (db *Dao) SubscribeInvalidations(ctx context.Context) (<-chan string, error) {
+	if db.notifyChannel == "" {
+		return nil, errNotifyDisabled
+	}
+	if db.Dsn == "" {
+		return nil, errNotifyNoDsn
+	}
+
+	listener := pq.NewListener(db.Dsn, notifyMinReconnectInterval, notifyMaxReconnectInterval, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			db.logger.Errorf("session invalidations listener: %v", err)
+		}
+	})
+
+	if err := listener.Listen(db.notifyChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	invalidations := make(chan string)
+
+	go func() {
+		defer close(invalidations)
+		defer listener.Close()
+
+		ticker := time.NewTicker(notifyPingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				go listener.Ping()
+			case n := <-listener.Notify:
+				if n == nil { // reconnected
+					continue
+				}
+
+				select {
+				case invalidations <- n.Extra:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return invalidations, nil
+}
+
+// execNotifyContext run fn, a statement changing the session, and notify its invalidation
+// in the same transaction when the notify channel is configured
+func (db *Dao) execNotifyContext(ctx context.Context, sessionID []byte, fn func(db *Dao) (int64, error)) (int64, error) {
+	return db.execNotifyQueryContext(ctx, fn, db.sqlNotify, gotils.B2S(sessionID))
+}
+
+// execNotifyIDsContext run fn, a statement changing the sessions, and notify the invalidation
+// of every id in the same transaction when the notify channel is configured
+func (db *Dao) execNotifyIDsContext(ctx context.Context, sessionIDs [][]byte, fn func(db *Dao) (int64, error)) (int64, error) {
+	return db.execNotifyQueryContext(ctx, fn, db.sqlNotifyIDs, sessionIDsArray(sessionIDs))
+}
+
+// execNotifyAllContext run fn, a statement changing many sessions, and notify the invalidation
+// of all of them with an empty id in the same transaction when the notify channel is configured
+func (db *Dao) execNotifyAllContext(ctx context.Context, fn func(db *Dao) (int64, error)) (int64, error) {
+	return db.execNotifyQueryContext(ctx, fn, db.sqlNotify, "")
+}
+
+// execNotifyQueryContext run fn and the notify query of its invalidations in a transaction,
+// unless fn changes no session
+func (db *Dao) execNotifyQueryContext(ctx context.Context, fn func(db *Dao) (int64, error), query string, payload interface{}) (int64, error) {
+	if db.notifyChannel == "" {
+		return fn(db)
+	}
+
+	var n int64
+
+	err := db.WithTx(ctx, func(tx *Dao) error {
+		var err error
+		if n, err = fn(tx); err != nil || n == 0 {
+			return err
+		}
+
+		_, err = tx.execContext(ctx, opNotify, query, tx.notifyChannel, payload)
+
+		return err
+	})
+
+	return n, err
+}
+
+// notifyAllContext notify the invalidation of all the sessions when the notify channel is configured,
+// after a statement whose changed sessions are unknown like TRUNCATE
+func (db *Dao) notifyAllContext(ctx context.Context) error {
+	if db.notifyChannel == "" {
+		return nil
+	}
+
+	_, err := db.execContext(ctx, opNotify, db.sqlNotify, db.notifyChannel, "")
+
+	return err
+}
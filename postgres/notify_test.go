@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// notified payloads of the pg_notify function of the sqlite3_notify driver
+var notified struct {
+	mu       sync.Mutex
+	payloads []string
+}
+
+func init() {
+	sql.Register("sqlite3_notify", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("pg_notify", func(channel, payload string) int64 {
+				notified.mu.Lock()
+				notified.payloads = append(notified.payloads, payload)
+				notified.mu.Unlock()
+
+				return 1
+			}, false)
+		},
+	})
+}
+
+// takeNotified return and reset the notified payloads
+func takeNotified() []string {
+	notified.mu.Lock()
+	defer notified.mu.Unlock()
+
+	payloads := notified.payloads
+	notified.payloads = nil
+
+	return payloads
+}
+
+func TestSQLiteDaoNotifyBulkWrites(t *testing.T) {
+	db, err := NewDaoWithConfig("sqlite3_notify", ":memory:", "session", DaoConfig{
+		Dialect:       SQLiteDialect,
+		MaxOpenConns:  1,
+		NotifyChannel: "session_invalidation",
+		EnsureTable:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	takeNotified()
+
+	db.save([]byte("old"), []byte("contents"), now, time.Hour)
+	if _, err = db.regenerateKeepContents([]byte("old"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if payloads := takeNotified(); !reflect.DeepEqual(payloads, []string{"old"}) {
+		t.Errorf("regenerateKeepContents() notified %q, want %q", payloads, []string{"old"})
+	}
+
+	db.save([]byte("expired"), nil, now.Add(-time.Hour), time.Minute)
+	if n, err := db.deleteExpiredSessions(); err != nil || n != 1 {
+		t.Fatalf("deleteExpiredSessions() == %d, %v, want %d", n, err, 1)
+	}
+	if n, err := db.deleteExpiredSessions(); err != nil || n != 0 {
+		t.Fatalf("deleteExpiredSessions() == %d, %v, want %d", n, err, 0)
+	}
+	if payloads := takeNotified(); !reflect.DeepEqual(payloads, []string{""}) {
+		t.Errorf("deleteExpiredSessions() notified %q, want %q", payloads, []string{""})
+	}
+
+	if _, err = db.deleteAll(); err != nil {
+		t.Fatal(err)
+	}
+	if payloads := takeNotified(); !reflect.DeepEqual(payloads, []string{""}) {
+		t.Errorf("deleteAll() notified %q, want %q", payloads, []string{""})
+	}
+}
+
+func TestSubscribeInvalidationsWithoutDsn(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	db, err := NewDaoWithDBConfig(conn, "session", DaoConfig{Dialect: SQLiteDialect, NotifyChannel: "session_invalidation"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = db.SubscribeInvalidations(context.Background()); err != errNotifyNoDsn {
+		t.Errorf("SubscribeInvalidations() == %v, want %v", err, errNotifyNoDsn)
+	}
+}
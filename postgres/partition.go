@@ -0,0 +1,233 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/savsgio/gotils"
+)
+
+// CreatePartition create the partition of the sessions last active from from, included,
+// to to, excluded, of the partitioned table when it does not exist
+//
+// The partition is named after the table and its bounds in the time unit of the Dao,
+// as table_p<from>_<to>, so DropPartitionsOlderThan finds it. The ranges of the partitions
+// must not overlap, like the consecutive days created by a daily job
+func (db *Dao) CreatePartition(from, to time.Time) error {
+	return db.CreatePartitionContext(context.Background(), from, to)
+}
+
+// CreatePartitionContext create the partition of the sessions last active from from to to bound to ctx
+func (db *Dao) CreatePartitionContext(ctx context.Context, from, to time.Time) error {
+	ctx, cancel := db.withOpTimeout(ctx, opPartition)
+	defer cancel()
+
+	if !db.partitioned {
+		return errPartitionsDisabled
+	}
+
+	lower, upper := db.unixTime(from), db.unixTime(to)
+	query := "CREATE TABLE IF NOT EXISTS " + db.partitionName(lower, upper) + " PARTITION OF " + db.quotedTableName +
+		" FOR VALUES FROM (" + strconv.FormatInt(lower, 10) + ") TO (" + strconv.FormatInt(upper, 10) + ")"
+
+	return db.run(ctx, opPartition, query, func() error {
+		_, err := db.ExecContext(ctx, query)
+		return err
+	})
+}
+
+// DropPartitionsOlderThan drop the partitions of the sessions last active before cutoff,
+// the ones whose end is not after it, returning the number of dropped partitions
+//
+// The sessions of the dropped partitions are gone, whatever their expiration.
+// Only the partitions named by CreatePartition are considered
+func (db *Dao) DropPartitionsOlderThan(cutoff time.Time) (int, error) {
+	return db.DropPartitionsOlderThanContext(context.Background(), cutoff)
+}
+
+// DropPartitionsOlderThanContext drop the partitions of the sessions last active before cutoff bound to ctx
+func (db *Dao) DropPartitionsOlderThanContext(ctx context.Context, cutoff time.Time) (int, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opPartition)
+	defer cancel()
+
+	if !db.partitioned {
+		return 0, errPartitionsDisabled
+	}
+
+	var names []string
+
+	err := db.run(ctx, opPartition, db.sqlListPartitions, func() error {
+		names = names[:0]
+
+		rows, err := db.QueryContext(ctx, db.sqlListPartitions, db.quotedTableName)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var name string
+			if err = rows.Scan(&name); err != nil {
+				return err
+			}
+			names = append(names, name)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	end := db.unixTime(cutoff)
+
+	dropped := 0
+	for _, name := range names {
+		_, upper, ok := db.parsePartitionName(name)
+		if !ok || upper > end {
+			continue
+		}
+
+		query := "DROP TABLE IF EXISTS " + db.quotedPartitionName(name)
+		err = db.run(ctx, opPartition, query, func() error {
+			_, err := db.ExecContext(ctx, query)
+			return err
+		})
+		if err != nil {
+			return dropped, err
+		}
+		dropped++
+	}
+
+	return dropped, nil
+}
+
+// gcPartitions drop the partitions older than the retention, when it is set
+func (db *Dao) gcPartitions(ctx context.Context) error {
+	if !db.partitioned || db.partitionRetention <= 0 {
+		return nil
+	}
+
+	n, err := db.DropPartitionsOlderThanContext(ctx, db.clock.Now().Add(-db.partitionRetention))
+	if n > 0 {
+		db.logger.Debugf("session gc dropped %d partitions", n)
+	}
+
+	return err
+}
+
+// partitionPrefix return the prefix of the partition names of the table
+func (db *Dao) partitionPrefix() string {
+	return db.tableParts[len(db.tableParts)-1] + "_p"
+}
+
+// partitionName return the quoted name of the partition of the bounds,
+// in the schema of the table
+func (db *Dao) partitionName(lower, upper int64) string {
+	return db.quotedPartitionName(db.partitionPrefix() + strconv.FormatInt(lower, 10) + "_" + strconv.FormatInt(upper, 10))
+}
+
+// quotedPartitionName return the quoted name of the partition, in the schema of the table
+func (db *Dao) quotedPartitionName(name string) string {
+	parts := append([]string(nil), db.tableParts...)
+	parts[len(parts)-1] = name
+
+	return quoteQualifiedName(db.dialect, parts)
+}
+
+// parsePartitionName return the bounds of the partition named by CreatePartition
+func (db *Dao) parsePartitionName(name string) (int64, int64, bool) {
+	prefix := db.partitionPrefix()
+	if !strings.HasPrefix(name, prefix) {
+		return 0, 0, false
+	}
+
+	bounds := strings.Split(name[len(prefix):], "_")
+	if len(bounds) != 2 {
+		return 0, 0, false
+	}
+
+	lower, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	upper, err := strconv.ParseInt(bounds[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return lower, upper, true
+}
+
+// lockSessionIDContext lock the session id until the end of the transaction,
+// standing for the unique constraint a partitioned table can not have
+func (db *Dao) lockSessionIDContext(ctx context.Context, op string, sessionID []byte) error {
+	_, err := db.execContext(ctx, op, db.sqlLockSessionID, db.quotedTableName, gotils.B2S(sessionID))
+
+	return err
+}
+
+// save the encoded contents of the session of a partitioned table bound to ctx,
+// updating it or inserting it when it does not exist
+func (db *Dao) savePartitionedContext(ctx context.Context, sessionID, contents []byte, lastActive time.Time, expiration time.Duration) (int64, error) {
+	var n int64
+
+	err := db.WithTx(ctx, func(tx *Dao) error {
+		if err := tx.lockSessionIDContext(ctx, opSave, sessionID); err != nil {
+			return err
+		}
+
+		var err error
+		n, err = tx.execContext(ctx, opSave, tx.sqlUpdateBySessionID, tx.contentsArg(contents), tx.unixTime(lastActive), tx.units(expiration), gotils.B2S(sessionID))
+		if err != nil || n > 0 {
+			return err
+		}
+
+		n, err = tx.execContext(ctx, opSave, tx.sqlInsert, gotils.B2S(sessionID), tx.contentsArg(contents), tx.unixTime(lastActive), tx.units(expiration))
+
+		return err
+	})
+
+	return n, err
+}
+
+// get the alive session of a partitioned table or create it with the encoded contents bound to ctx,
+// replacing an expired one
+func (db *Dao) getOrCreatePartitionedContext(ctx context.Context, sessionID, contents []byte, lastActive time.Time, expiration time.Duration) (*DBRow, bool, error) {
+	var row *DBRow
+	var created bool
+
+	err := db.WithTx(ctx, func(tx *Dao) error {
+		if err := tx.lockSessionIDContext(ctx, opGetOrCreate, sessionID); err != nil {
+			return err
+		}
+
+		var err error
+		row, err = tx.getSessionBySessionIDContext(ctx, sessionID)
+		if err != ErrSessionNotFound {
+			return err
+		}
+
+		if _, err = tx.execContext(ctx, opGetOrCreate, tx.sqlDeleteBySessionID, gotils.B2S(sessionID)); err != nil {
+			return err
+		}
+		if _, err = tx.execContext(ctx, opGetOrCreate, tx.sqlInsert, gotils.B2S(sessionID), tx.contentsArg(contents), tx.unixTime(lastActive), tx.units(expiration)); err != nil {
+			return err
+		}
+		created = true
+
+		row, err = tx.fetchDBRow(ctx, opGetOrCreate, tx.sqlGetSessionBySessionID, func() (*sql.Row, error) {
+			return tx.queryRowContext(ctx, tx.sqlGetSessionBySessionID, gotils.B2S(sessionID), tx.now())
+		})
+
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return row, created, nil
+}
@@ -0,0 +1,80 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPartitionName(t *testing.T) {
+	db, err := newDao("app.session", DaoConfig{Partitioned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name := db.partitionName(100, 200); name != `"app"."session_p100_200"` {
+		t.Errorf("partitionName() == %s, want %s", name, `"app"."session_p100_200"`)
+	}
+
+	if lower, upper, ok := db.parsePartitionName("session_p100_200"); !ok || lower != 100 || upper != 200 {
+		t.Errorf("parsePartitionName() == %d, %d, %v, want %d, %d", lower, upper, ok, 100, 200)
+	}
+	for _, name := range []string{"session", "session_p100", "session_pabc_200", "other_p100_200"} {
+		if _, _, ok := db.parsePartitionName(name); ok {
+			t.Errorf("parsePartitionName(%s) unexpected success", name)
+		}
+	}
+}
+
+func TestPartitionedDialect(t *testing.T) {
+	if _, err := newDao("session", DaoConfig{Partitioned: true, Dialect: SQLiteDialect}); err != errPartitionsUnsupported {
+		t.Errorf("newDao() == %v, want %v", err, errPartitionsUnsupported)
+	}
+
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	if err := db.CreatePartition(time.Now(), time.Now().Add(time.Hour)); err != errPartitionsDisabled {
+		t.Errorf("CreatePartition() == %v, want %v", err, errPartitionsDisabled)
+	}
+}
+
+func TestDaoPartitions(t *testing.T) {
+	conn := getTestDao(t, DaoConfig{})
+	conn.Close()
+
+	db, err := NewDaoWithConfig(conn.Driver, conn.Dsn, "session_partitioned_test", DaoConfig{Partitioned: true, EnsureTable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer db.Exec("DROP TABLE " + db.quotedTableName)
+
+	day := time.Now().Truncate(24 * time.Hour)
+	if err := db.CreatePartition(day.Add(-48*time.Hour), day.Add(-24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreatePartition(day.Add(-24*time.Hour), day.Add(24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.save([]byte("old"), nil, day.Add(-36*time.Hour), SessionNeverExpires); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.save([]byte("recent"), nil, time.Now(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.save([]byte("recent"), []byte("updated"), time.Now(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := db.DropPartitionsOlderThan(day.Add(-24 * time.Hour)); err != nil || n != 1 {
+		t.Errorf("DropPartitionsOlderThan() == %d, %v, want %d", n, err, 1)
+	}
+
+	if _, err := db.getSessionBySessionID([]byte("old")); err != ErrSessionNotFound {
+		t.Errorf("getSessionBySessionID() == %v, want %v", err, ErrSessionNotFound)
+	}
+	if row, err := db.getSessionBySessionID([]byte("recent")); err != nil || row.contents != "updated" {
+		t.Errorf("getSessionBySessionID() == %v, %v, want %s", row, err, "updated")
+	}
+}
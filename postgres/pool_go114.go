@@ -0,0 +1,12 @@
+//go:build !go1.15
+// +build !go1.15
+
+package postgres
+
+import (
+	"database/sql"
+	"time"
+)
+
+// setConnMaxIdleTime ignore the maximum idle time, database/sql supports it since go 1.15
+func setConnMaxIdleTime(conn *sql.DB, d time.Duration) {}
@@ -0,0 +1,14 @@
+//go:build go1.15
+// +build go1.15
+
+package postgres
+
+import (
+	"database/sql"
+	"time"
+)
+
+// setConnMaxIdleTime set the maximum idle time of the connections of the pool
+func setConnMaxIdleTime(conn *sql.DB, d time.Duration) {
+	conn.SetConnMaxIdleTime(d)
+}
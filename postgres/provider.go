@@ -1,6 +1,8 @@
 package postgres
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"time"
 
@@ -63,41 +65,42 @@ func (pp *Provider) Init(expiration time.Duration, cfg session.ProviderConfig) e
 	}
 
 	var err error
-	pp.db, err = NewDao("postgres", pp.config.getPostgresDSN(), pp.config.TableName)
-	if err != nil {
-		return err
-	}
-	pp.db.Connection.SetMaxOpenConns(pp.config.SetMaxIdleConn)
-	pp.db.Connection.SetMaxIdleConns(pp.config.SetMaxIdleConn)
+	pp.db, err = NewDaoWithConfig("postgres", pp.config.getPostgresDSN(), pp.config.TableName, DaoConfig{
+		MaxOpenConns:            pp.config.SetMaxOpenConn,
+		MaxIdleConns:            pp.config.SetMaxIdleConn,
+		VerifyConnection:        true,
+		StatementTimeout:        pp.config.StatementTimeout,
+		ConnectRetryMaxAttempts: pp.config.ConnectRetryMaxAttempts,
+		ConnectRetryBaseDelay:   pp.config.ConnectRetryBaseDelay,
+	})
 
-	return pp.db.Connection.Ping()
+	return err
 }
 
 // Get read session store by session id
 func (pp *Provider) Get(sessionID []byte) (session.Storer, error) {
-	store := pp.acquireStore(sessionID, pp.expiration)
+	return pp.GetContext(context.Background(), sessionID)
+}
 
-	row, err := pp.db.getSessionBySessionID(sessionID)
+// GetContext read session store by session id,
+// aborting the in-flight queries when ctx is cancelled
+func (pp *Provider) GetContext(ctx context.Context, sessionID []byte) (session.Storer, error) {
+	row, created, err := pp.db.getOrCreateContext(ctx, sessionID, nil, pp.db.clock.Now(), pp.expiration)
 	if err != nil {
 		return nil, err
 	}
+	defer releaseDBRow(row)
 
-	if row.sessionID != "" { // Exist
-		err = pp.config.UnSerializeFunc(store.DataPointer(), gotils.S2B(row.contents))
-		if err != nil {
-			return nil, err
-		}
+	store := pp.acquireStore(sessionID, pp.expiration)
 
-	} else { // Not exist
-		_, err = pp.db.insert(sessionID, nil, time.Now().Unix(), pp.expiration)
+	if !created { // Exist
+		err = pp.config.UnSerializeFunc(store.DataPointer(), gotils.S2B(row.contents))
 		if err != nil {
+			pp.releaseStore(store)
 			return nil, err
 		}
-
 	}
 
-	releaseDBRow(row)
-
 	return store, nil
 }
 
@@ -108,47 +111,62 @@ func (pp *Provider) Put(store session.Storer) {
 
 // Regenerate regenerate session
 func (pp *Provider) Regenerate(oldID, newID []byte) (session.Storer, error) {
-	store := pp.acquireStore(newID, pp.expiration)
-
-	row, err := pp.db.getSessionBySessionID(oldID)
-	if err != nil {
-		return nil, err
-	}
-
-	now := time.Now().Unix()
+	return pp.RegenerateContext(context.Background(), oldID, newID)
+}
 
-	if row.sessionID != "" { // Exists
-		_, err = pp.db.regenerate(oldID, newID, now, pp.expiration)
+// RegenerateContext regenerate session,
+// aborting the in-flight queries when ctx is cancelled
+func (pp *Provider) RegenerateContext(ctx context.Context, oldID, newID []byte) (session.Storer, error) {
+	row, err := pp.db.getSessionBySessionIDContext(ctx, oldID)
+	if errors.Is(err, ErrSessionNotFound) {
+		_, err = pp.db.insertContext(ctx, newID, nil, pp.db.clock.Now(), pp.expiration)
 		if err != nil {
 			return nil, err
 		}
 
-		err = pp.config.UnSerializeFunc(store.DataPointer(), gotils.S2B(row.contents))
-		if err != nil {
-			return nil, err
-		}
+		return pp.acquireStore(newID, pp.expiration), nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer releaseDBRow(row)
 
-	} else { // Not exist
-		_, err = pp.db.insert(newID, nil, now, pp.expiration)
-		if err != nil {
-			return nil, err
-		}
+	_, err = pp.db.regenerateContext(ctx, oldID, newID, pp.db.clock.Now(), pp.expiration)
+	if err != nil {
+		return nil, err
 	}
 
-	releaseDBRow(row)
+	store := pp.acquireStore(newID, pp.expiration)
+
+	err = pp.config.UnSerializeFunc(store.DataPointer(), gotils.S2B(row.contents))
+	if err != nil {
+		pp.releaseStore(store)
+		return nil, err
+	}
 
 	return store, nil
 }
 
 // Destroy destroy session by sessionID
 func (pp *Provider) Destroy(sessionID []byte) error {
-	_, err := pp.db.deleteBySessionID(sessionID)
+	return pp.DestroyContext(context.Background(), sessionID)
+}
+
+// DestroyContext destroy session by sessionID,
+// aborting the in-flight query when ctx is cancelled
+func (pp *Provider) DestroyContext(ctx context.Context, sessionID []byte) error {
+	_, err := pp.db.deleteBySessionIDContext(ctx, sessionID)
 	return err
 }
 
 // Count session values count
 func (pp *Provider) Count() int {
-	return pp.db.countSessions()
+	return pp.CountContext(context.Background())
+}
+
+// CountContext session values count,
+// aborting the in-flight query when ctx is cancelled
+func (pp *Provider) CountContext(ctx context.Context) int {
+	return pp.db.countSessionsContext(ctx)
 }
 
 // NeedGC need gc
@@ -158,12 +176,24 @@ func (pp *Provider) NeedGC() bool {
 
 // GC session garbage collection
 func (pp *Provider) GC() {
-	_, err := pp.db.deleteExpiredSessions()
+	var err error
+
+	if pp.config.GCBatchSize > 0 {
+		_, err = pp.db.deleteExpiredSessionsBatch(pp.config.GCBatchSize)
+	} else {
+		_, err = pp.db.deleteExpiredSessions()
+	}
+
 	if err != nil {
 		panic(err)
 	}
 }
 
+// Close close the provider database connection pool
+func (pp *Provider) Close() error {
+	return pp.db.Close()
+}
+
 // register session provider
 func init() {
 	err := session.Register(ProviderName, provider)
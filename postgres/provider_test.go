@@ -0,0 +1,148 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/session"
+)
+
+func getSQLiteTestProvider(t *testing.T, cfg *Config) *Provider {
+	if cfg.SerializeFunc == nil {
+		cfg.SerializeFunc = encrypt.Base64Encode
+	}
+	if cfg.UnSerializeFunc == nil {
+		cfg.UnSerializeFunc = encrypt.Base64Decode
+	}
+
+	pp := NewProvider()
+	pp.config = cfg
+	pp.expiration = time.Hour
+	pp.db = getSQLiteTestDao(t)
+
+	return pp
+}
+
+func saveTestSession(t *testing.T, pp *Provider, sessionID []byte, key, value string) {
+	data := session.Dict{}
+	data.Set(key, value)
+
+	contents, err := pp.config.SerializeFunc(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pp.db.save(sessionID, contents, time.Now(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProviderGetContext(t *testing.T) {
+	pp := getSQLiteTestProvider(t, &Config{})
+	defer pp.Close()
+
+	store, err := pp.GetContext(context.Background(), []byte("created"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pp.Put(store)
+
+	if total := pp.Count(); total != 1 {
+		t.Errorf("Count() == %d, want %d", total, 1)
+	}
+
+	saveTestSession(t, pp, []byte("existing"), "user", "alice")
+
+	store, err = pp.GetContext(context.Background(), []byte("existing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := store.Get("user"); value != "alice" {
+		t.Errorf("Get() == %v, want %v", value, "alice")
+	}
+	pp.Put(store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if store, err = pp.GetContext(ctx, []byte("existing")); !errors.Is(err, context.Canceled) || store != nil {
+		t.Errorf("GetContext() == %v, %v, want %v, %v", store, err, nil, context.Canceled)
+	}
+}
+
+func TestProviderGetContextUnSerializeError(t *testing.T) {
+	invalid := errors.New("invalid contents")
+
+	pp := getSQLiteTestProvider(t, &Config{
+		UnSerializeFunc: func(dst *session.Dict, src []byte) error {
+			return invalid
+		},
+	})
+	defer pp.Close()
+
+	saveTestSession(t, pp, []byte("existing"), "user", "alice")
+
+	if store, err := pp.GetContext(context.Background(), []byte("existing")); err != invalid || store != nil {
+		t.Errorf("GetContext() == %v, %v, want %v, %v", store, err, nil, invalid)
+	}
+	if store, err := pp.RegenerateContext(context.Background(), []byte("existing"), []byte("renewed")); err != invalid || store != nil {
+		t.Errorf("RegenerateContext() == %v, %v, want %v, %v", store, err, nil, invalid)
+	}
+}
+
+func TestProviderRegenerateContext(t *testing.T) {
+	pp := getSQLiteTestProvider(t, &Config{})
+	defer pp.Close()
+
+	store, err := pp.RegenerateContext(context.Background(), []byte("missing"), []byte("fresh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := string(store.GetSessionID()); id != "fresh" {
+		t.Errorf("GetSessionID() == %s, want %s", id, "fresh")
+	}
+	pp.Put(store)
+
+	saveTestSession(t, pp, []byte("old"), "user", "alice")
+
+	store, err = pp.RegenerateContext(context.Background(), []byte("old"), []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := store.Get("user"); value != "alice" {
+		t.Errorf("Get() == %v, want %v", value, "alice")
+	}
+	pp.Put(store)
+
+	if _, err = pp.db.getSessionBySessionID([]byte("old")); err != ErrSessionNotFound {
+		t.Errorf("getSessionBySessionID() of the old id error == %v, want %v", err, ErrSessionNotFound)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if store, err = pp.RegenerateContext(ctx, []byte("new"), []byte("newer")); !errors.Is(err, context.Canceled) || store != nil {
+		t.Errorf("RegenerateContext() == %v, %v, want %v, %v", store, err, nil, context.Canceled)
+	}
+}
+
+func TestProviderGC(t *testing.T) {
+	for _, batchSize := range []int{0, 2} {
+		pp := getSQLiteTestProvider(t, &Config{GCBatchSize: batchSize})
+
+		now := time.Now()
+		for _, id := range []string{"expired1", "expired2", "expired3"} {
+			pp.db.save([]byte(id), nil, now.Add(-time.Hour), time.Minute)
+		}
+		pp.db.save([]byte("alive"), nil, now, time.Hour)
+
+		pp.GC()
+
+		if total := pp.Count(); total != 1 {
+			t.Errorf("Count() with GCBatchSize %d == %d, want %d", batchSize, total, 1)
+		}
+
+		pp.Close()
+	}
+}
@@ -0,0 +1,119 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// retryableErrorCodes postgres error codes of the transient failures worth a retry
+var retryableErrorCodes = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+}
+
+// connectionLostErrorCodes postgres error codes of a connection terminated by the server
+var connectionLostErrorCodes = map[string]bool{
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+}
+
+// isConnectionLost report whether err is a lost connection to the database,
+// like a bad connection, a network error or a server shutdown
+//
+// A cancelled or expired context is not, even though context.DeadlineExceeded is a net.Error
+func isConnectionLost(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	if state := sqlState(err); state != "" {
+		return connectionLostErrorCodes[state] || strings.HasPrefix(state, "08") // connection_exception
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr)
+}
+
+// isRetryable report whether err is a transient failure,
+// like a lost connection or a serialization failure
+func isRetryable(err error) bool {
+	if isConnectionLost(err) {
+		return true
+	}
+
+	return retryableErrorCodes[sqlState(err)]
+}
+
+// retry run fn until it succeeds, fails with a non retryable error
+// or the maximum attempts are reached, waiting an exponential backoff
+// from the base delay between the attempts
+//
+// An attempt failing with a lost connection recovers the pools before the next one
+func (db *Dao) retry(ctx context.Context, fn func() error) error {
+	err := fn()
+
+	for attempt := 1; attempt < db.retryMaxAttempts && err != nil && isRetryable(err); attempt++ {
+		timer := time.NewTimer(db.retryBaseDelay << uint(attempt-1))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		if isConnectionLost(err) {
+			db.recoverConnection(ctx)
+		}
+
+		err = fn()
+	}
+
+	return err
+}
+
+// recoverConnection close the idle connections of the pools, likely broken
+// by the failover which dropped a connection, and ping them to open a fresh one
+//
+// The prepared statements need no care: database/sql prepares them again
+// on the new connections. Only one recovery runs at a time, the concurrent
+// callers return immediately, and none runs inside a transaction
+// whose connection is pinned
+func (db *Dao) recoverConnection(ctx context.Context) {
+	m := db.monitor
+	if m == nil || db.tx != nil || !atomic.CompareAndSwapUint32(&m.recovering, 0, 1) {
+		return
+	}
+	defer atomic.StoreUint32(&m.recovering, 0)
+
+	atomic.StoreUint32(&m.lost, 0)
+
+	conns := []*sql.DB{db.Connection}
+	if db.ReadConnection != nil {
+		conns = append(conns, db.ReadConnection)
+	}
+
+	for _, conn := range conns {
+		if !db.sharedConnection {
+			conn.SetMaxIdleConns(0)
+			conn.SetMaxIdleConns(m.maxIdleConns)
+		}
+
+		if err := conn.PingContext(ctx); err != nil {
+			db.logger.Errorf("session reconnect failed: %v", err)
+		}
+	}
+}
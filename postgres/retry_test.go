@@ -0,0 +1,101 @@
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestIsRetryable(t *testing.T) {
+	cases := map[error]bool{
+		driver.ErrBadConn:                                       true,
+		&pq.Error{Code: "40001"}:                                true,
+		&pq.Error{Code: "57P01"}:                                true,
+		&pq.Error{Code: "08006"}:                                true,
+		&pq.Error{Code: "23505"}:                                false,
+		errors.New("unexpected arguments"):                      false,
+		context.Canceled:                                        false,
+		context.DeadlineExceeded:                                false,
+		fmt.Errorf("session get: %w", context.Canceled):         false,
+		fmt.Errorf("session get: %w", context.DeadlineExceeded): false,
+	}
+
+	for err, expected := range cases {
+		if retryable := isRetryable(err); retryable != expected {
+			t.Errorf("isRetryable(%v) == %v, want %v", err, retryable, expected)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	db := &Dao{retryMaxAttempts: 3}
+
+	attempts := 0
+	err := db.retry(context.Background(), func() error {
+		attempts++
+		return driver.ErrBadConn
+	})
+	if err != driver.ErrBadConn {
+		t.Errorf("retry() error == %v, want %v", err, driver.ErrBadConn)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts == %d, want %d", attempts, 3)
+	}
+
+	attempts = 0
+	uniqueViolation := &pq.Error{Code: "23505"}
+	db.retry(context.Background(), func() error {
+		attempts++
+		return uniqueViolation
+	})
+	if attempts != 1 {
+		t.Errorf("attempts == %d, want %d", attempts, 1)
+	}
+}
+
+func TestIsConnectionLost(t *testing.T) {
+	cases := map[error]bool{
+		driver.ErrBadConn:                                       true,
+		&pq.Error{Code: "57P01"}:                                true,
+		&pq.Error{Code: "08006"}:                                true,
+		&pq.Error{Code: "40001"}:                                false,
+		errors.New("unexpected arguments"):                      false,
+		context.Canceled:                                        false,
+		context.DeadlineExceeded:                                false,
+		fmt.Errorf("session get: %w", context.Canceled):         false,
+		fmt.Errorf("session get: %w", context.DeadlineExceeded): false,
+	}
+
+	for err, expected := range cases {
+		if lost := isConnectionLost(err); lost != expected {
+			t.Errorf("isConnectionLost(%v) == %v, want %v", err, lost, expected)
+		}
+	}
+}
+
+func TestRunRecoversLostConnection(t *testing.T) {
+	db := getSQLiteTestDao(t)
+	defer db.Close()
+
+	db.run(context.Background(), opGet, "", func() error {
+		return driver.ErrBadConn
+	})
+	if lost := atomic.LoadUint32(&db.monitor.lost); lost != 1 {
+		t.Errorf("lost == %d, want %d", lost, 1)
+	}
+
+	if err := db.run(context.Background(), opGet, "", func() error { return nil }); err != nil {
+		t.Errorf("run() error == %v, want %v", err, nil)
+	}
+	if lost := atomic.LoadUint32(&db.monitor.lost); lost != 0 {
+		t.Errorf("lost == %d, want %d", lost, 0)
+	}
+	if err := db.Connection.Ping(); err != nil {
+		t.Errorf("Ping() error == %v, want %v", err, nil)
+	}
+}
@@ -0,0 +1,35 @@
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+// searchPathConnector connector setting the search path of every new connection
+// of the wrapped connector, before the pool uses it
+type searchPathConnector struct {
+	driver.Connector
+
+	query string
+}
+
+// Connect open a connection with the wrapped connector and set its search path
+func (c *searchPathConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		conn.Close()
+		return nil, errSearchPathConnection
+	}
+
+	if _, err = execer.ExecContext(ctx, c.query, nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
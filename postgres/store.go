@@ -1,18 +1,21 @@
 package postgres
 
-import (
-	"time"
-)
+import "context"
 
 // Save save store
 func (ps *Store) Save() error {
+	return ps.SaveContext(context.Background())
+}
+
+// SaveContext save store, aborting the in-flight query when ctx is cancelled
+func (ps *Store) SaveContext(ctx context.Context) error {
 	data := ps.GetAll()
 	value, err := provider.config.SerializeFunc(data)
 	if err != nil {
 		return err
 	}
 
-	_, err = provider.db.updateBySessionID(ps.GetSessionID(), value, time.Now().Unix(), ps.GetExpiration())
+	_, err = provider.db.saveContext(ctx, ps.GetSessionID(), value, provider.db.clock.Now(), ps.GetExpiration())
 
 	return err
 }
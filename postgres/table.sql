@@ -3,8 +3,8 @@ DROP TABLE IF EXISTS session;
 CREATE TABLE IF NOT EXISTS session (
   session_id VARCHAR(64) PRIMARY KEY NOT NULL DEFAULT '',
   contents TEXT NOT NULL,
-  last_active INT NOT NULL DEFAULT '0',
-  expiration INT NOT NULL DEFAULT '0'
+  last_active BIGINT NOT NULL DEFAULT '0',
+  expiration BIGINT NOT NULL DEFAULT '0'
 );
 
 CREATE INDEX last_active ON SESSION (last_active);
@@ -0,0 +1,131 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Table return a Dao of another session table of the same database, sharing the connection pool
+//
+// The Dao of every table is built on first use and cached per tenant, so the Dao
+// of a tenant scoped Dao is scoped to the same tenant. It has no background
+// garbage collector and closing it is a no-op, the pool is closed with the original Dao.
+// The Dao of a transaction scoped Dao runs in the transaction and is never cached
+func (db *Dao) Table(tableName string) (*Dao, error) {
+	if tableName == db.tableName {
+		return db, nil
+	}
+	if db.tx != nil {
+		return db.newTableDao(tableName)
+	}
+
+	key := tableKey{tenantID: db.tenantID, tableName: tableName}
+
+	db.tables.mu.RLock()
+	tdb := db.tables.daos[key]
+	db.tables.mu.RUnlock()
+
+	if tdb != nil {
+		return tdb, nil
+	}
+
+	db.tables.mu.Lock()
+	defer db.tables.mu.Unlock()
+
+	if tdb = db.tables.daos[key]; tdb != nil {
+		return tdb, nil
+	}
+
+	tdb, err := db.newTableDao(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	db.tables.daos[key] = tdb
+
+	return tdb, nil
+}
+
+// newTableDao build a copy of the Dao for another session table, keeping its scope
+func (db *Dao) newTableDao(tableName string) (*Dao, error) {
+	tdb := new(Dao)
+	*tdb = *db
+	tdb.closed = 1
+	tdb.gc = nil
+
+	if err := tdb.setTableName(tableName); err != nil {
+		return nil, err
+	}
+	tdb.buildQueries()
+
+	return tdb, nil
+}
+
+// get session by sessionID from the given table of the same database
+func (db *Dao) getSessionBySessionIDFrom(tableName string, sessionID []byte) (*DBRow, error) {
+	tdb, err := db.Table(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	return tdb.getSessionBySessionID(sessionID)
+}
+
+// copy the alive sessions of the Dao to the dst Dao, like another table or database,
+// in batches of batchSize sessions ordered by session id, returning the copied sessions
+//
+// The rows are decoded and written with the settings of dst, converting the time unit,
+// the contents encoding and the columns
+func (db *Dao) copyTo(dst *Dao, batchSize int) (int64, error) {
+	n, _, err := db.copyToAfterContext(context.Background(), dst, nil, batchSize)
+
+	return n, err
+}
+
+// copy the alive sessions with an id after afterID to the dst Dao, returning the copied sessions
+// and the id of the last one
+//
+// Every batch is inserted in a transaction, so after a failure the copy is resumed
+// from the returned id without duplicates. A nil afterID copies all the sessions
+func (db *Dao) copyToAfter(dst *Dao, afterID []byte, batchSize int) (int64, []byte, error) {
+	return db.copyToAfterContext(context.Background(), dst, afterID, batchSize)
+}
+
+// copy the alive sessions with an id after afterID to the dst Dao bound to ctx
+//
+// The copy has no timeout by default, every inserted batch being bounded by the one of the inserts
+func (db *Dao) copyToAfterContext(ctx context.Context, dst *Dao, afterID []byte, batchSize int) (int64, []byte, error) {
+	ctx, cancel := db.withOpTimeout(ctx, opCopy)
+	defer cancel()
+
+	if batchSize <= 0 {
+		batchSize = insertBatchSize
+	}
+
+	lastID := string(afterID)
+	now := db.clock.Now()
+
+	var total int64
+	for {
+		rows, err := db.fetchDBRows(ctx, opCopy, db.sqlListAfter, func() (*sql.Rows, error) {
+			return db.readContext(ctx, db.sqlListAfter, lastID, db.unixTime(now), batchSize)
+		})
+		if err != nil {
+			return total, []byte(lastID), err
+		}
+		if len(rows) == 0 {
+			return total, []byte(lastID), nil
+		}
+
+		n, err := dst.insertBatchContext(ctx, rows)
+		if err != nil {
+			return total, []byte(lastID), err
+		}
+		total += n
+		lastID = rows[len(rows)-1].sessionID
+
+		if len(rows) < batchSize {
+			return total, []byte(lastID), nil
+		}
+	}
+}
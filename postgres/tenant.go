@@ -0,0 +1,31 @@
+package postgres
+
+// ForTenant return a copy of the Dao scoped to the sessions of the tenant,
+// sharing the connection pool, of a multi-tenant mode Dao
+//
+// Every statement of the copy reads and writes the sessions of the tenant only,
+// so a session id guessed from another tenant is not found.
+// The Dao itself is scoped to the empty tenant id. The copy neither runs
+// the garbage collector nor closes the pool, Close is a no-op on it
+func (db *Dao) ForTenant(tenantID string) (*Dao, error) {
+	if !db.multiTenant {
+		return nil, errMultiTenantDisabled
+	}
+
+	tdb := new(Dao)
+	*tdb = *db
+	tdb.closed = 1
+	tdb.gc = nil
+	tdb.tenantID = tenantID
+
+	return tdb, nil
+}
+
+// tenantArgs append the tenant id to the arguments of the tenant scoped queries
+func (db *Dao) tenantArgs(query string, args []interface{}) []interface{} {
+	if !db.tenantQueries[query] {
+		return args
+	}
+
+	return append(args, db.tenantID)
+}
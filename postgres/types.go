@@ -1,6 +1,10 @@
 package postgres
 
 import (
+	"context"
+	"crypto/cipher"
+	"database/sql"
+	"database/sql/driver"
 	"sync"
 	"time"
 
@@ -32,19 +36,88 @@ type Config struct {
 	// session table name
 	TableName string
 
+	// ssl mode of the connection, like require or verify-full (default is disable)
+	SSLMode string
+
+	// path of the root certificate authority file verifying the server certificate
+	SSLRootCert string
+
+	// path of the client certificate file
+	SSLCert string
+
+	// path of the client private key file
+	SSLKey string
+
+	// application name of the connections, shown by pg_stat_activity (default is the table name)
+	ApplicationName string
+
+	// server enforced ceiling of every statement, 0 disables it
+	StatementTimeout time.Duration
+
+	// attempts of the initial ping of the database not reachable yet, 0 or 1 disables the retries
+	ConnectRetryMaxAttempts int
+
+	// delay before the first retry of the initial ping (default is 1s), doubled on every next one
+	ConnectRetryBaseDelay time.Duration
+
 	// postgres max free idle
 	SetMaxIdleConn int
 
 	// postgres max open idle
 	SetMaxOpenConn int
 
+	// maximum expired sessions deleted per statement by the gc,
+	// 0 means all at once
+	GCBatchSize int
+
 	// session value serialize func
 	SerializeFunc func(src session.Dict) ([]byte, error)
 
 	// session value unSerialize func
+	//
+	// The src bytes share the memory of the contents read from the database,
+	// it must not modify them nor retain them after it returns
 	UnSerializeFunc func(dst *session.Dict, src []byte) error
 }
 
+// ConnectionOptions postgres connection options assembled into a DSN
+type ConnectionOptions struct {
+
+	// The host to connect to. Values that start with / are for unix domain sockets. (default is localhost)
+	Host string
+
+	// The port to bind to. (default is 5432)
+	Port int64
+
+	// postgres user to sign in as
+	Username string
+
+	// postgres user's password
+	Password string
+
+	// name of the database to connect to
+	Database string
+
+	// Maximum wait for connection, in seconds. Zero or
+	// not specified means wait indefinitely.
+	ConnTimeout int64
+
+	// ssl mode of the connection: disable, require, verify-ca or verify-full
+	SSLMode string
+
+	// path of the root certificate authority file verifying the server certificate
+	SSLRootCert string
+
+	// path of the client certificate file
+	SSLCert string
+
+	// path of the client private key file
+	SSLKey string
+
+	// application name of the connections, shown by pg_stat_activity
+	ApplicationName string
+}
+
 // Provider provider struct
 type Provider struct {
 	config     *Config
@@ -59,25 +132,530 @@ type Store struct {
 	session.Store
 }
 
+// DaoConfig database access object connection pool configuration
+//
+// Zero values leave the database/sql driver defaults untouched
+type DaoConfig struct {
+
+	// maximum number of open connections to the database
+	MaxOpenConns int
+
+	// maximum number of connections in the idle connection pool
+	MaxIdleConns int
+
+	// maximum amount of time a connection may be reused
+	ConnMaxLifetime time.Duration
+
+	// maximum amount of time a connection may be idle before being closed,
+	// ignored by the go versions before 1.15
+	ConnMaxIdleTime time.Duration
+
+	// ping the database on creation to fail fast on a wrong dsn,
+	// leave it disabled for lazy connections
+	VerifyConnection bool
+
+	// create the session table and its indexes when they do not exist,
+	// recreating them and retrying once when the table is dropped while running
+	EnsureTable bool
+
+	// session table column names, empty ones fall back to the defaults
+	Columns ColumnNames
+
+	// optional read replica dsn, the read only queries are routed to it
+	// unless their context is marked with WithPrimary
+	ReadDsn string
+
+	// sql dialect of the database (default is PostgresDialect)
+	Dialect Dialect
+
+	// factory of the values of the contents column, called for every row scanned
+	// or written, nil scans it into a plain string
+	ContentsValue func() ContentsValue
+
+	// codec of the values of saveValue and loadValue (default is JSONCodec).
+	// The binary output of GobCodec requires BinaryContents, or the EncryptionKey
+	// whose contents are base64 encoded, a text column refusing its NUL bytes
+	Codec Codec
+
+	// 32 bytes key to encrypt the session contents at rest with AES-GCM,
+	// nil stores them in plaintext
+	EncryptionKey []byte
+
+	// gzip the session contents longer than this number of bytes,
+	// 0 disables the compression. Once enabled, disabling it requires
+	// the compressed rows to be rewritten or expired first
+	CompressionThreshold int
+
+	// store the session contents in a jsonb column, so they can be queried,
+	// it can not be combined with the encryption or the compression
+	JSONContents bool
+
+	// store the session contents in a bytea column, so the binary serialized ones
+	// are written as is instead of being forced into a text column,
+	// it can not be combined with the JSON contents mode
+	BinaryContents bool
+
+	// current version of the structure of the session contents, 0 disables the versioning.
+	// The contents are written with a header of a marker byte and this version,
+	// the ones written before are read as the version 0
+	ContentsVersion byte
+
+	// upgrade functions of the contents of a version to the next one, keyed by the version,
+	// chained on read up to ContentsVersion. The upgraded contents are not written back,
+	// the next write of the session stores them with the current version
+	ContentsUpgrades map[byte]func(contents []byte) ([]byte, error)
+
+	// refresh the last activity of the session on every read
+	SlidingExpiration bool
+
+	// unit of the last activity, expiration and the other time columns (default is time.Second),
+	// like time.Millisecond for the sub-second expirations.
+	// Changing it on an existing table misreads the stored sessions
+	TimeUnit time.Duration
+
+	// store an absolute deadline per session in the expires at column, 0 meaning none,
+	// so a session expires at its deadline independently of its activity.
+	// The existing tables need the column added, as BIGINT NOT NULL DEFAULT 0
+	ExpiresAt bool
+
+	// postgres channel notified with the session ids deleted or regenerated, and an empty id
+	// after the deletes of many sessions, so the other nodes can invalidate their caches
+	// with SubscribeInvalidations, empty disables the notifications.
+	// It is not supported by the mysql dialect
+	NotifyChannel string
+
+	// store the creation unix time of the sessions in the created at column, set by the inserts only.
+	// The existing tables need the column added, as BIGINT NOT NULL DEFAULT 0.
+	// It is not supported by the mysql dialect
+	CreatedAt bool
+
+	// key the sessions by tenant id and session id, the statements of a Dao
+	// being scoped to its tenant, see ForTenant. The primary key of the existing tables
+	// needs the tenant id column, as VARCHAR(64) NOT NULL DEFAULT ''.
+	// The expired sessions of all the tenants are garbage collected together.
+	// It is not supported by the mysql dialect
+	MultiTenant bool
+
+	// logger of the slow queries and the errors (default discards everything)
+	Logger Logger
+
+	// queries lasting at least this duration are logged as slow, 0 disables it
+	SlowQueryThreshold time.Duration
+
+	// optional observer of the queries latency and errors
+	Metrics Metrics
+
+	// optional tracer of the queries, e.g. an OpenTelemetry adapter
+	Tracer Tracer
+
+	// source of the current time of the expirations, the last activities and the gc
+	// (default is the system clock), a fake one advances the time of the tests instantly
+	Clock Clock
+
+	// maximum attempts of the statements failing with a transient error,
+	// like a lost connection or a serialization failure, 0 or 1 disables the retries
+	//
+	// A lost connection closes the idle connections of the pool and pings it
+	// before the next attempt or, without retries, before the next statement.
+	// Along with ConnMaxLifetime it smooths over a failover
+	RetryMaxAttempts int
+
+	// delay before the first retry, doubled on every next one
+	RetryBaseDelay time.Duration
+
+	// attempts of the ping verifying the connection while the database is not reachable yet,
+	// like a container started before it, 0 or 1 disables the retries
+	//
+	// Only a lost connection, like a refused one or a server still starting up, is retried,
+	// the other errors, like a failed authentication, fail immediately
+	ConnectRetryMaxAttempts int
+
+	// delay before the first retry of the ping (default is 1s), doubled on every next one
+	// up to 30s
+	ConnectRetryBaseDelay time.Duration
+
+	// consecutive lost connections opening the circuit breaker of the database,
+	// 0 disables it. While open, the calls fail fast with ErrCircuitOpen instead of
+	// waiting for the connection timeout, and once the cooldown has elapsed the next call
+	// pings the database, closing the circuit on success
+	CircuitBreakerThreshold int
+
+	// time the circuit breaker stays open before probing the database again (default is 5s)
+	CircuitBreakerCooldown time.Duration
+
+	// server enforced ceiling of every statement, whatever the context of the caller,
+	// set on the connections with the options parameter of the dsn, 0 disables it.
+	// The pools of NewDaoWithDB and NewDaoFromConnector get it with SET LOCAL
+	// inside the transactions only
+	StatementTimeout time.Duration
+
+	// timeouts of the operations, bounding the context of every call, keyed by the operation
+	// names of the Metrics: get, count, list, iterate, find, consume, update, touch, expire_at,
+	// delete, gc, insert, import, copy, get_or_create, save, regenerate and partition.
+	// They override the defaults, 1 minute for the operations on single sessions and none
+	// for gc, import, copy, iterate and partition, and 0 or a negative one removes the timeout.
+	// A get on the request path may get 50ms while the gc keeps its time
+	OperationTimeouts map[string]time.Duration
+
+	// schemas of the search_path of the connections, resolving the unqualified table name
+	// of the queries, empty leaves the server default. It requires a postgres compatible dialect.
+	// It is set with the options parameter of the dsn, or with SET search_path on every
+	// new connection of NewDaoFromConnector. The pools of NewDaoWithDB get it with SET LOCAL
+	// inside the transactions only, which may also run their own SET LOCAL search_path
+	// to serve another schema
+	SearchPath []string
+
+	// interval before probing again a dsn of NewDaoWithFailover found down (default is 30s)
+	FailoverProbeInterval time.Duration
+
+	// do not cache prepared statements, needed behind poolers
+	// like pgbouncer in transaction mode
+	DisableStatementCache bool
+
+	// run every background gc cycle holding a postgres advisory lock of the table,
+	// so a single instance of the cluster deletes the expired sessions,
+	// the others skip the cycle, only supported by the postgres dialect
+	GCAdvisoryLock bool
+
+	// maximum random delay added to every background gc interval,
+	// to spread the cycles of the instances started together
+	GCJitter time.Duration
+
+	// delete the expired sessions of every background gc cycle in chunks of at most
+	// this number of rows, so no statement locks them all at once, 0 deletes them
+	// in a single statement. The cycles calling OnExpire are not chunked
+	GCBatchSize int
+
+	// called with every session deleted by the gc, like to audit the terminations,
+	// the expired sessions are then deleted with DELETE RETURNING instead of a plain DELETE.
+	// It is nil by default, keeping the plain delete
+	OnExpire func(row *DBRow)
+
+	// create the session table partitioned by range of the last activity, postgres only,
+	// so the old sessions are dropped with their partitions instead of deleted row by row
+	//
+	// The partitions are created ahead with CreatePartition. A partitioned table can not
+	// hold a unique session id across its partitions, so save and getOrCreate lock the
+	// session id in a transaction instead of upserting it
+	Partitioned bool
+
+	// age of the end of the partitions dropped by the gc before deleting the expired sessions,
+	// 0 keeps all of them. It must exceed the longest expiration, the never expiring
+	// sessions idle for longer are dropped too
+	PartitionRetention time.Duration
+
+	// maximum number of sessions cached in memory in front of getSessionBySessionID,
+	// 0 disables the cache for a strict consistency
+	//
+	// The writes of the Dao invalidate the cached sessions, but the ones written
+	// by the other instances or outside the Dao are served stale for up to CacheTTL.
+	// The cache is bypassed with sliding expiration and inside the transactions
+	CacheSize int
+
+	// validity of the cached sessions after being read (default is 1s)
+	CacheTTL time.Duration
+}
+
+// ColumnNames session table column names
+//
+// They must be plain identifiers, letters, digits and underscores, since they are
+// interpolated unquoted in the queries and folded to lower case by postgres
+type ColumnNames struct {
+
+	// session id column (default is session_id)
+	SessionID string
+
+	// session contents column (default is contents)
+	Contents string
+
+	// last activity unix time column (default is last_active)
+	LastActive string
+
+	// expiration seconds column (default is expiration)
+	Expiration string
+
+	// absolute deadline unix time column of the expires at mode (default is expires_at)
+	ExpiresAt string
+
+	// creation unix time column of the created at mode (default is created_at)
+	CreatedAt string
+
+	// tenant id column of the multi-tenant mode (default is tenant_id)
+	TenantID string
+}
+
+// Logger logger of the Dao
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// Metrics observer of the Dao queries, e.g. to feed a prometheus histogram
+//
+// The operation is a short name out of a small fixed set, like get, insert or gc.
+// The duration includes the retries, and a query without rows is not reported as an error
+type Metrics interface {
+	ObserveQuery(op string, duration time.Duration, err error)
+}
+
+// ContentsValue value of the contents column, scanned from the driver and written back to it,
+// like a wrapper encrypting or compressing the contents at the driver level
+//
+// Scan receives the raw column and Contents returns the contents it holds, SetContents
+// receives the contents to write and Value returns the raw column. The contents are the
+// encoded ones of the Dao, before its own decompression and decryption
+type ContentsValue interface {
+	sql.Scanner
+	driver.Valuer
+
+	Contents() string
+	SetContents(contents []byte)
+}
+
+// Codec encoder and decoder of the session values into the session contents
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Tracer starter of a span around every Dao query, so the sessions show up in the traces
+//
+// The span is named session.<op>, after the operation reported to Metrics,
+// and is derived from the context given to the context aware methods.
+// The attributes follow the OpenTelemetry database conventions: db.operation,
+// db.sql.table and db.statement. The returned function ends the span with the
+// query error, nil for a query without rows. It keeps the package free of any
+// tracing dependency, an OpenTelemetry adapter is a few lines:
+//
+//	func (t otelTracer) StartSpan(ctx context.Context, name string, attrs map[string]string) func(error) {
+//		_, span := t.tracer.Start(ctx, name)
+//		for k, v := range attrs {
+//			span.SetAttributes(attribute.String(k, v))
+//		}
+//		return func(err error) {
+//			if err != nil {
+//				span.RecordError(err)
+//			}
+//			span.End()
+//		}
+//	}
+type Tracer interface {
+	StartSpan(ctx context.Context, name string, attrs map[string]string) func(err error)
+}
+
+// Clock source of the current time of the Dao
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock clock of the system time
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// noopLogger logger discarding everything
+type noopLogger struct{}
+
+// Dialect sql dialect used to build the statements of the Dao
+type Dialect interface {
+
+	// Placeholder return the bind parameter of the n-th argument, starting at 1
+	Placeholder(n int) string
+
+	// QuoteIdentifier quote a table or column name
+	QuoteIdentifier(name string) string
+}
+
 // Dao database access object
+//
+// The session ids and the contents passed as byte slices reach the driver without a copy,
+// through gotils.B2S. They are only read while the call runs and never retained after it
+// returns, so the caller may reuse or pool them afterwards, but must not modify them before,
+// e.g. from another goroutine, or the query may write corrupted data
 type Dao struct {
 	session.Dao
 
+	// optional read replica connection of the read only queries
+	ReadConnection *sql.DB
+
+	tableName       string
+	tableParts      []string
+	quotedTableName string
+	columns         ColumnNames
+	dialect         Dialect
+	closed          uint32
+	tx              *sql.Tx
+	stmts           *stmtCache
+	readStmts       *stmtCache
+	gc              *gcWorker
+	tables          *tableRegistry
+	monitor         *connectionMonitor
+	breaker         *circuitBreaker
+	aead            cipher.AEAD
+	codec           Codec
+	contentsValue   func() ContentsValue
+
+	// the connection pool is managed by the caller
+	sharedConnection bool
+
+	compressionThreshold int
+	jsonContents         bool
+	binaryContents       bool
+	contentsVersion      byte
+	contentsUpgrades     map[byte]func(contents []byte) ([]byte, error)
+
+	slidingExpiration bool
+	expiresAt         bool
+	createdAt         bool
+	timeUnit          time.Duration
+	copyOnRegenerate  bool
+	notifyChannel     string
+	ensureTable       bool
+
+	// the tenant of the statements of the multi-tenant mode,
+	// bound as the last parameter of the tenant queries
+	multiTenant   bool
+	tenantID      string
+	tenantQueries map[string]bool
+
+	// the $n order of the arguments of the statements of a positional dialect,
+	// for the ones not binding every parameter once in ascending order
+	argOrders map[string][]int
+
+	logger             Logger
+	slowQueryThreshold time.Duration
+	metrics            Metrics
+	tracer             Tracer
+	clock              Clock
+	retryMaxAttempts   int
+	retryBaseDelay     time.Duration
+	statementTimeout   time.Duration
+	searchPath         string
+	opTimeouts         map[string]time.Duration
+
+	gcAdvisoryLock bool
+	gcLockKey      int64
+	gcJitter       time.Duration
+	gcBatchSize    int
+	onExpire       func(row *DBRow)
+	cache          *rowCache
+
+	partitioned        bool
+	partitionRetention time.Duration
+
+	sqlGetSessionBySessionID      string
+	sqlListSessions               string
+	sqlGetBySessionIDs            string
+	sqlListAfter                  string
+	sqlListIdleBefore             string
+	sqlListExpiringWithin         string
+	sqlIterate                    string
+	sqlCountSessions              string
+	sqlCountActiveSessions        string
+	sqlCountExpiredSessions       string
+	sqlCountByExpirationBucket    string
+	sqlTotalContentsBytes         string
+	sqlTotalContentsBytesByTenant string
+	sqlApproxCountSessions        string
+	sqlUpdateBySessionID          string
+	sqlUpdateIfLastActive         string
+	sqlGetAndTouch                string
+	sqlTouchAlive                 string
+	sqlUpdateExpiration           string
+	sqlExtendAll                  string
+	sqlUpdateContents             string
+	sqlTouch                      string
+	sqlDeleteBySessionID          string
+	sqlDeleteAndReturn            string
+	sqlGetStoredBySessionID       string
+	sqlDeleteBySessionIDs         string
+	sqlDeleteBySessionIDsIn       string
+	sqlDeleteByJSONField          string
+	sqlConsumeUse                 string
+	sqlDeleteByContentsLike       string
+	sqlDeleteAll                  string
+	sqlTruncate                   string
+	sqlDeleteExpiredSessions      string
+	sqlDeleteExpiredSessionsBatch string
+	sqlEnforceMaxSessions         string
+	sqlDeleteExpiredReturning     string
+	sqlLockSessionID              string
+	sqlListPartitions             string
+	sqlListExpiredSessions        string
+	sqlInsert                     string
+	sqlInsertFull                 string
+	sqlInsertBatch                string
+	insertBatchValues             string
+	insertBatchParams             int
+	sqlInsertIfNotExists          string
+	sqlSave                       string
+	sqlRegenerate                 string
+	sqlRegenerateCopy             string
+	sqlRegenerateWithContents     string
+	sqlRegenerateWithContentsCopy string
+	sqlRegenerateKeepContents     string
+	sqlExpireAt                   string
+	sqlFindByJSONField            string
+	sqlNotify                     string
+	sqlNotifyIDs                  string
+	sqlHealthCheck                string
+	sqlTryGCLock                  string
+	sqlCreateTable                string
+	sqlCreateIndex                string
+}
+
+// stmtCache prepared statements by query
+type stmtCache struct {
+	mu    sync.RWMutex
+	stmts map[string]*sql.Stmt
+}
+
+// gcWorker background garbage collector of the expired sessions
+type gcWorker struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// tableRegistry Dao of the other session tables sharing the connection pool, by tenant and table name
+type tableRegistry struct {
+	mu   sync.RWMutex
+	daos map[tableKey]*Dao
+}
+
+// tableKey key of a Dao in the table registry, the tenant scoping the Dao of the table
+type tableKey struct {
+	tenantID  string
 	tableName string
+}
+
+// connectionMonitor connection loss state of the pools, shared by the Dao copies
+type connectionMonitor struct {
+	lost         uint32
+	recovering   uint32
+	maxIdleConns int
+}
+
+// primaryContextKey context key to require the primary connection
+type primaryContextKey struct{}
 
-	sqlGetSessionBySessionID string
-	sqlCountSessions         string
-	sqlUpdateBySessionID     string
-	sqlDeleteBySessionID     string
-	sqlDeleteExpiredSessions string
-	sqlInsert                string
-	sqlRegenerate            string
+// rowScanner common interface of *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
 }
 
 // DBRow database row definition
+//
+// The single session reads return a pooled row owned by the caller,
+// who must Release it once done and not use it afterwards.
+// The rows of the multiple sessions reads are not pooled, releasing them is optional
 type DBRow struct {
 	sessionID  string
 	contents   string
-	lastActive int64
+	lastActive time.Time
 	expiration time.Duration
+	expiresAt  time.Time
+	createdAt  time.Time
 }